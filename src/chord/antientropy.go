@@ -0,0 +1,220 @@
+package chord
+
+import (
+	"bytes"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// anti-entropy repairs the backups the replicas keep of the data of a node.
+// The owner compares the Merkle tree over its data in (pred, self] with the
+// one over the backup of each replica in the same range, from the root down
+// to the differing leaves, and only the keys of these leaves are sent. The
+// newer of two records of a key wins on both sides. The tree over a range
+// of an engine is kept until the engine is written to or another range is
+// asked for, so that the calls of an exchange share one tree
+
+// MerkleRequest names the nodes of the Merkle tree over the backup keys in
+// (LOWER, UPPER]
+type MerkleRequest struct {
+	Lower    Identifer
+	Upper    Identifer
+	Prefixes []string
+}
+
+// treeCache keeps the last tree built over an engine
+type treeCache struct {
+	lock   sync.Mutex
+	engine *watchedEngine
+	gen    uint64
+	lower  Identifer
+	upper  Identifer
+	tree   *merkleTree
+}
+
+// the tree over the keys of E in (LOWER, UPPER] and whether it was kept,
+// E is read locked by the caller so that it is not written to meanwhile
+func (c *treeCache) get(e *watchedEngine, lower, upper Identifer, hash func(string) Identifer) (*merkleTree, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	gen := e.generation()
+	if c.tree != nil && c.engine == e && c.gen == gen && c.lower == lower && c.upper == upper {
+		return c.tree, true
+	}
+	c.tree = buildMerkle(func(add func(KeyType, ValueType)) {
+		e.ForEach(func(k, v string) bool {
+			if contain(hash(k), lower, upper, "(]") {
+				add(k, v)
+			}
+			return true
+		})
+	})
+	c.engine, c.gen, c.lower, c.upper = e, gen, lower, upper
+	return c.tree, false
+}
+
+func (n *chordBaseNode) backupTree(lower, upper Identifer) *merkleTree {
+	n.backupLock.RLock()
+	defer n.backupLock.RUnlock()
+	tree, kept := n.backupTrees.get(n.backup, lower, upper, n.hash)
+	merkleTrees.With(n.addr, "backup", hitOrMiss(kept)).Inc()
+	return tree
+}
+
+func (n *chordBaseNode) dataTree(lower, upper Identifer) *merkleTree {
+	n.dataLock.RLock()
+	defer n.dataLock.RUnlock()
+	tree, kept := n.dataTrees.get(n.data, lower, upper, n.hash)
+	merkleTrees.With(n.addr, "data", hitOrMiss(kept)).Inc()
+	return tree
+}
+
+// digests of the requested nodes of the tree over the backup, the empty
+// ones are left out
+func (n *chordBaseNode) BackupDigest(req MerkleRequest, reply *map[string][]byte) error {
+	*reply = n.backupTree(req.Lower, req.Upper).digestsOf(req.Prefixes)
+	return nil
+}
+
+// backup pairs held by the requested leaves of the tree over the backup
+func (n *chordBaseNode) BackupLeaves(req MerkleRequest, reply *StoreType) error {
+	*reply = n.backupTree(req.Lower, req.Upper).pairsOf(req.Prefixes)
+	return nil
+}
+
+// run a round of anti-entropy with every replica of the node, the
+// tombstones older than tombstoneTTL are dropped first
+func (n *chordBaseNode) AntiEntropy(_ string, _ *string) error {
+	var (
+		pred    Address
+		targets []Address
+	)
+	n.purgeTombstones()
+	n.GetPredecessor(NIL, &pred)
+	if pred == NIL || pred == n.addr {
+		// the range of the node is not known
+		return nil
+	}
+	n.GetReplicas(NIL, &targets)
+	if len(targets) == 0 {
+		return nil
+	}
+	lower, upper := n.idOf(pred), n.self
+	tree := n.dataTree(lower, upper)
+	// keys found only in a backup are dropped only if no node is known to
+	// lie between the predecessor and the node, as the range would be
+	// too wide otherwise and cover the keys of that node
+	var predSucc Address
+	confirmed := n.call(pred, "ChordService", "GetSuccessor", NIL, &predSucc) == nil &&
+		predSucc == n.addr
+	var ret error
+	for _, target := range targets {
+		err := n.syncReplica(target, tree, MerkleRequest{Lower: lower, Upper: upper}, confirmed)
+		antiEntropyRounds.With(n.addr, outcome(err)).Inc()
+		if err != nil {
+			n.errLogger(err).WithField("target", target).
+				Warn("anti-entropy warning")
+			ret = err
+		}
+	}
+	return ret
+}
+
+func (n *chordBaseNode) purgeTombstones() {
+	before := n.cfg.Clock.Now().Add(-tombstoneTTL).UnixNano()
+	n.dataLock.Lock()
+	cnt := purgeTombstones(n.data, before)
+	n.dataLock.Unlock()
+	n.backupLock.Lock()
+	cnt += purgeTombstones(n.backup, before)
+	n.backupLock.Unlock()
+	if cnt > 0 {
+		n.logger().WithField("dropped", cnt).Info("tombstones purged")
+	}
+}
+
+func (n *chordBaseNode) syncReplica(target Address, tree *merkleTree, req MerkleRequest, drop bool) error {
+	// descend from the root along the differing nodes
+	prefixes := []string{""}
+	for len(prefixes) > 0 && len(prefixes[0]) < merkleDepth {
+		var theirs map[string][]byte
+		req.Prefixes = prefixes
+		if err := n.call(target, "ChordService", "BackupDigest", req, &theirs); err != nil {
+			return err
+		}
+		var next []string
+		for _, p := range prefixes {
+			if !bytes.Equal(tree.digests[p], theirs[p]) {
+				next = append(next, merkleChildren(p)...)
+			}
+		}
+		prefixes = next
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	// compare the leaves still in question
+	var (
+		mine   = tree.pairsOf(prefixes)
+		theirs = make(StoreType)
+		push   = make(StoreType)
+		pull   = make(StoreType)
+		stale  []KeyType
+	)
+	req.Prefixes = prefixes
+	if err := n.call(target, "ChordService", "BackupLeaves", req, &theirs); err != nil {
+		return err
+	}
+	for k, v := range mine {
+		w, ok := theirs[k]
+		switch {
+		case !ok || decodeRecord(v).newer(decodeRecord(w)):
+			push[k] = v
+		case w != v:
+			// a write may have reached the replica and missed the node
+			pull[k] = w
+		}
+	}
+	if drop {
+		n.dataLock.RLock()
+		for k := range theirs {
+			if _, ok := mine[k]; ok {
+				continue
+			}
+			// the key may have been put since the tree was built
+			if _, ok := n.data.Get(k); !ok {
+				stale = append(stale, k)
+			}
+		}
+		n.dataLock.RUnlock()
+	}
+	if len(push) == 0 && len(pull) == 0 && len(stale) == 0 {
+		return nil
+	}
+	n.logger().WithFields(log.Fields{
+		"target": target,
+		"pushed": len(push),
+		"pulled": len(pull),
+		"stale":  len(stale),
+	}).Info("anti-entropy repairing backup")
+	if len(pull) > 0 {
+		if err := n.AppendData(pull, nil); err != nil {
+			return err
+		}
+		antiEntropyRepairs.With(n.addr, "pulled").Add(float64(len(pull)))
+	}
+	if len(push) > 0 {
+		if err := n.call(target, "ChordService", "AppendBackup", push, nil); err != nil {
+			return err
+		}
+		antiEntropyRepairs.With(n.addr, "pushed").Add(float64(len(push)))
+	}
+	if len(stale) > 0 {
+		if err := n.call(target, "ChordService", "DropBackup", stale, nil); err != nil {
+			return err
+		}
+		antiEntropyRepairs.With(n.addr, "dropped").Add(float64(len(stale)))
+	}
+	return nil
+}
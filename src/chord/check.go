@@ -0,0 +1,250 @@
+package chord
+
+import (
+	"DHT-2022/src/ident"
+	"DHT-2022/src/network"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Violation is an inconsistency of the ring found at NODE
+type Violation struct {
+	Node   Address
+	Kind   string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Node, v.Kind, v.Detail)
+}
+
+// RingReport is the outcome of a ring check, RING lists the nodes in the
+// order of their identifiers starting from the smallest one
+type RingReport struct {
+	Ring       []NodeInfo
+	Violations []Violation
+}
+
+// Converged reports whether the ring check found nothing wrong
+func (r RingReport) Converged() bool {
+	return len(r.Violations) == 0
+}
+
+func (r RingReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d nodes on the ring, %d violations\n", len(r.Ring), len(r.Violations))
+	for _, v := range r.Violations {
+		fmt.Fprintf(&b, "  %s\n", v)
+	}
+	return b.String()
+}
+
+// ringChecker reads the state of the nodes over its own pool, without
+// joining the network
+type ringChecker struct {
+	pool   *network.Pool
+	cfg    Config
+	report RingReport
+	// IDs reported by the nodes, which may not be the hashes of their
+	// addresses
+	ids map[Address]Identifer
+}
+
+// CheckRing walks the ring from the node at START through successor
+// pointers and checks that the predecessors point back, that the successor
+// lists follow the order of the identifiers, and that each key is kept by
+// its owner and backed up by the CFG.ReplicaNum successors after it. The
+// nodes must use the M, Hash and transport of CFG, secured as set by
+// CFG.TLS. An error is returned only if START can not be read
+func CheckRing(ctx context.Context, start Address, cfg Config) (RingReport, error) {
+	if err := cfg.Validate(); err != nil {
+		return RingReport{}, err
+	}
+	cfg = cfg.withDefaults()
+	var err error
+	if cfg.Transport, err = network.Secure(cfg.Transport, cfg.TLS); err != nil {
+		return RingReport{}, err
+	}
+	c := &ringChecker{cfg: cfg, ids: make(map[Address]Identifer)}
+	c.pool = newPool(NIL, cfg)
+	defer c.pool.Close()
+
+	first, err := c.info(ctx, start)
+	if err != nil {
+		return RingReport{}, err
+	}
+	nodes := c.walk(ctx, first)
+	nodes = c.discover(ctx, nodes)
+	sort.Slice(nodes, func(i, j int) bool {
+		return c.id(nodes[i].Addr).Cmp(c.id(nodes[j].Addr)) < 0
+	})
+	c.report.Ring = nodes
+	c.checkLinks(nodes)
+	c.checkKeys(ctx, nodes)
+	return c.report, nil
+}
+
+func (c *ringChecker) info(ctx context.Context, addr Address) (NodeInfo, error) {
+	var info NodeInfo
+	err := c.pool.Call(ctx, addr, "ChordService.GetNodeInfo", NIL, &info)
+	if id, ok := ident.ParseID(info.ID); err == nil && ok {
+		c.ids[addr] = id
+	}
+	return info, err
+}
+
+// ID reported by the node at ADDR, the hash of the address if it has not
+// been asked
+func (c *ringChecker) id(addr Address) Identifer {
+	if id, ok := c.ids[addr]; ok {
+		return id
+	}
+	return c.cfg.hash(addr)
+}
+
+func (c *ringChecker) violate(node Address, kind string, format string, args ...interface{}) {
+	c.report.Violations = append(c.report.Violations, Violation{node, kind, fmt.Sprintf(format, args...)})
+}
+
+// follow the first successors until the walk comes back, nodes visited
+// before a loop not passing by the first one are left out of the ring
+func (c *ringChecker) walk(ctx context.Context, first NodeInfo) []NodeInfo {
+	nodes := []NodeInfo{first}
+	index := map[Address]int{first.Addr: 0}
+	for len(nodes) < ringCheckMaxNodes {
+		cur := nodes[len(nodes)-1]
+		if !cur.Online {
+			c.violate(cur.Addr, "offline", "node is not in a network")
+		}
+		succ := NIL
+		if len(cur.SuccList) > 0 {
+			succ = cur.SuccList[0]
+		}
+		if succ == NIL {
+			c.violate(cur.Addr, "successor", "no successor, the ring is broken")
+			return nodes
+		}
+		if i, ok := index[succ]; ok {
+			if i > 0 {
+				for _, v := range nodes[:i] {
+					c.violate(v.Addr, "off ring", "successors lead into a loop not passing by the node")
+				}
+				nodes = nodes[i:]
+			}
+			return nodes
+		}
+		info, err := c.info(ctx, succ)
+		if err != nil {
+			c.violate(cur.Addr, "successor", "successor %s can not be read: %v", succ, err)
+			return nodes
+		}
+		index[succ] = len(nodes)
+		nodes = append(nodes, info)
+	}
+	c.violate(nodes[0].Addr, "walk", "gave up after %d nodes", ringCheckMaxNodes)
+	return nodes
+}
+
+// nodes referred to by the ring but not on it are taken into the ring
+// order if they are online
+func (c *ringChecker) discover(ctx context.Context, nodes []NodeInfo) []NodeInfo {
+	known := make(map[Address]bool)
+	for _, v := range nodes {
+		known[v.Addr] = true
+	}
+	for i := 0; i < len(nodes); i++ {
+		refs := append([]Address{nodes[i].Pred}, nodes[i].SuccList...)
+		for _, ref := range refs {
+			if ref == NIL || known[ref] {
+				continue
+			}
+			known[ref] = true
+			info, err := c.info(ctx, ref)
+			if err != nil || !info.Online {
+				continue
+			}
+			c.violate(ref, "off ring", "node is online but not reached from the successors of the ring")
+			nodes = append(nodes, info)
+		}
+	}
+	return nodes
+}
+
+// check the predecessors and successor lists of NODES sorted by id
+func (c *ringChecker) checkLinks(nodes []NodeInfo) {
+	n := len(nodes)
+	for i, v := range nodes {
+		succ := nodes[(i+1)%n]
+		if succ.Pred != v.Addr {
+			c.violate(succ.Addr, "predecessor", "predecessor is %s, expected %s", orNil(succ.Pred), v.Addr)
+		}
+		for k := 0; k < len(v.SuccList) && k < n-1; k++ {
+			want := nodes[(i+1+k)%n].Addr
+			if v.SuccList[k] != want {
+				c.violate(v.Addr, "successor list", "succ[%d] is %s, expected %s", k, orNil(v.SuccList[k]), want)
+			}
+		}
+		if n == 1 && len(v.SuccList) > 0 && v.SuccList[0] != v.Addr {
+			c.violate(v.Addr, "successor list", "succ[0] is %s, expected itself", orNil(v.SuccList[0]))
+		}
+	}
+}
+
+// check that every key lies in (pred, self] of the node keeping it and
+// that its replicas back it up with the same value, deleted keys are left
+// out
+func (c *ringChecker) checkKeys(ctx context.Context, nodes []NodeInfo) {
+	n := len(nodes)
+	data := make([]StoreType, n)
+	backup := make([]StoreType, n)
+	for i, v := range nodes {
+		data[i], backup[i] = make(StoreType), make(StoreType)
+		if err := c.pool.Call(ctx, v.Addr, "ChordService.CopyData", NIL, &data[i]); err != nil {
+			c.violate(v.Addr, "keys", "data can not be read: %v", err)
+		}
+		if err := c.pool.Call(ctx, v.Addr, "ChordService.CopyBackup", NIL, &backup[i]); err != nil {
+			c.violate(v.Addr, "keys", "backup can not be read: %v", err)
+		}
+	}
+	if n == 1 {
+		return
+	}
+	replicas := c.cfg.ReplicaNum
+	if replicas > n-1 {
+		replicas = n - 1
+	}
+	for i, v := range nodes {
+		pred := nodes[(i+n-1)%n]
+		keys := make([]KeyType, 0, len(data[i]))
+		for k, val := range data[i] {
+			if !decodeRecord(val).Deleted {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			id := c.cfg.hash(k)
+			if !contain(id, c.id(pred.Addr), c.id(v.Addr), "(]") {
+				c.violate(v.Addr, "misplaced key", "key %q belongs to %s", k, c.owner(nodes, id))
+			}
+			for r := 1; r <= replicas; r++ {
+				succ := (i + r) % n
+				if b, ok := backup[succ][k]; !ok {
+					c.violate(v.Addr, "missing backup", "key %q is not backed up by %s", k, nodes[succ].Addr)
+				} else if b != data[i][k] {
+					c.violate(v.Addr, "stale backup", "key %q is backed up by %s with another value", k, nodes[succ].Addr)
+				}
+			}
+		}
+	}
+}
+
+// the first node of NODES sorted by id at or after ID
+func (c *ringChecker) owner(nodes []NodeInfo, id Identifer) Address {
+	i := sort.Search(len(nodes), func(i int) bool {
+		return c.id(nodes[i].Addr).Cmp(id) >= 0
+	})
+	return nodes[i%len(nodes)].Addr
+}
@@ -1,12 +1,102 @@
 package chord
 
+import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/identity"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/network"
+	"DHT-2022/src/store"
+	"context"
+	"errors"
+)
+
 type ChordNode struct {
 	base *chordBaseNode
 }
 
-func (n *ChordNode) Initialize(addr string) {
+var _ dht.Node = (*ChordNode)(nil)
+
+// Initialize sets up the node at ADDR tuned by CFG, zero fields of CFG
+// take the default values
+func (n *ChordNode) Initialize(addr string, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	logs, err := logging.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+	cfg = cfg.withDefaults()
+	if cfg.Transport, err = network.Secure(cfg.Transport, cfg.TLS); err != nil {
+		return err
+	}
 	n.base = new(chordBaseNode)
-	n.base.initialize(addr)
+	n.base.initialize(addr, cfg, logs)
+	return nil
+}
+
+// SetReplicaNum sets how many successors keep a copy of each key owned
+// by the node as Config.ReplicaNum does, it should be called before the
+// node joins the network
+func (n *ChordNode) SetReplicaNum(num int) bool {
+	// zero is taken as the default by the config
+	return num >= 1 && n.setConfig(func(cfg *Config) { cfg.ReplicaNum = num })
+}
+
+// SetLookupMode chooses how the node finds the successor of a key as
+// Config.LookupMode does
+func (n *ChordNode) SetLookupMode(mode LookupMode) {
+	n.setConfig(func(cfg *Config) { cfg.LookupMode = mode })
+}
+
+// SetMaxHops sets the number of nodes a lookup may go through as
+// Config.MaxHops does
+func (n *ChordNode) SetMaxHops(num int) bool {
+	return num >= 1 && n.setConfig(func(cfg *Config) { cfg.MaxHops = num })
+}
+
+// apply SET to the config of the node, false if the result is not valid
+func (n *ChordNode) setConfig(set func(*Config)) bool {
+	cfg := n.base.cfg
+	set(&cfg)
+	if cfg.Validate() != nil {
+		return false
+	}
+	n.base.cfg = cfg
+	return true
+}
+
+// SetStorage replaces the storage engines of the node with the ones
+// given by OPENER. It should be called before the node creates or joins
+// a network
+func (n *ChordNode) SetStorage(opener store.Opener) error {
+	return n.base.storeOpen(opener)
+}
+
+// SetDataDir makes the data of the node durable under DIR, data left by
+// a previous run on the same address is reloaded, in the old single log
+// layout too
+func (n *ChordNode) SetDataDir(dir string) error {
+	dir = nodeDir(dir, n.base.addr)
+	if err := upgradeDataDir(dir); err != nil {
+		return err
+	}
+	return n.SetStorage(store.Disk(dir))
+}
+
+// Lookup runs an iterative lookup for the successor of KEY regardless of
+// the lookup mode, the trace tells the path taken
+func (n *ChordNode) Lookup(ctx context.Context, key string) (LookupTrace, error) {
+	return n.base.lookup(ctx, n.base.hash(key))
+}
+
+func (n *ChordNode) Addr() string {
+	return n.base.addr
+}
+
+// Online reports whether the node is in a network
+func (n *ChordNode) Online() bool {
+	return n.base.onRing
 }
 
 func (n *ChordNode) Run() {
@@ -29,20 +119,82 @@ func (n *ChordNode) ForceQuit() {
 	n.base.forceQuit()
 }
 
+// Maintain runs the maintenance tasks due by the clock of the node, it is
+// for nodes configured as Manual and does nothing before Create or Join
+func (n *ChordNode) Maintain() {
+	n.base.round()
+}
+
 func (n *ChordNode) Ping(addr string) bool {
 	return n.base.ping(addr)
 }
 
+// Put tells whether VALUE is written to the copies of KEY the write level
+// needs, the copies beyond them missing it are reported by TryPut only,
+// with dht.ErrReplicaWrite
 func (n *ChordNode) Put(key, value string) bool {
-	return n.base.put(key, value)
+	err := n.TryPut(key, value)
+	return err == nil || errors.Is(err, dht.ErrReplicaWrite)
 }
 
 func (n *ChordNode) Get(key string) (bool, string) {
-	return n.base.get(key)
+	val, err := n.TryGet(key)
+	return err == nil, val
 }
 
+// Delete tells whether the owner of KEY deleted it, as Put does
 func (n *ChordNode) Delete(key string) bool {
-	return n.base.del(key)
+	err := n.TryDelete(key)
+	return err == nil || errors.Is(err, dht.ErrReplicaWrite)
+}
+
+func (n *ChordNode) TryPut(key, value string) error {
+	return n.PutCtx(context.Background(), key, value)
+}
+
+func (n *ChordNode) TryGet(key string) (string, error) {
+	return n.GetCtx(context.Background(), key)
+}
+
+func (n *ChordNode) TryDelete(key string) error {
+	return n.DeleteCtx(context.Background(), key)
+}
+
+func (n *ChordNode) PutCtx(ctx context.Context, key, value string) error {
+	return n.base.put(ctx, key, value)
+}
+
+func (n *ChordNode) GetCtx(ctx context.Context, key string) (string, error) {
+	return n.base.get(ctx, key)
+}
+
+// PutLevel writes VALUE under KEY like PutCtx, it succeeds once LEVEL of
+// the copies of the key are written
+func (n *ChordNode) PutLevel(ctx context.Context, key, value string, level Consistency) error {
+	return n.base.putRecord(ctx, key, record{Version: n.base.nextVersion(), Value: value}, level)
+}
+
+// PutSigned writes VALUE under KEY like PutCtx, signed with the identity
+// of the node. The nodes refuse to replace it later with a value not
+// signed by the node
+func (n *ChordNode) PutSigned(ctx context.Context, key, value string) error {
+	return n.base.putSigned(ctx, key, value, DefaultLevel)
+}
+
+// GetSigned reads KEY like GetCtx, the value tells its publisher if it is
+// signed, a copy whose signature does not hold is never returned
+func (n *ChordNode) GetSigned(ctx context.Context, key string) (identity.Value, error) {
+	return n.base.getSigned(ctx, key, DefaultLevel)
+}
+
+// GetLevel reads KEY like GetCtx, the newest of LEVEL copies of the key
+// is returned
+func (n *ChordNode) GetLevel(ctx context.Context, key string, level Consistency) (string, error) {
+	return n.base.getLevel(ctx, key, level)
+}
+
+func (n *ChordNode) DeleteCtx(ctx context.Context, key string) error {
+	return n.base.del(ctx, key)
 }
 
 // func (n *ChordNode) Print(key string) {
@@ -1,6 +1,9 @@
 package chord
 
 import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/identity"
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -12,25 +15,101 @@ type chordBaseNode struct {
 	networkNode
 	databaseNode
 
-	succLock   sync.RWMutex
-	predLock   sync.RWMutex
-	fingerLock sync.RWMutex
+	succLock    sync.RWMutex
+	predLock    sync.RWMutex
+	fingerLock  sync.RWMutex
+	replicaLock sync.Mutex
 
-	succList [succListLen]Address
+	succList []Address
 	pred     Address
-	finger   [M]Address
+	finger   []finger
+	replicas []Address
+
+	cfg Config
+
+	// ID of the node, the proof of its identity and the IDs proved by
+	// the peers
+	self   Identifer
+	proof  identity.Proof
+	idLock sync.Mutex
+	ids    map[Address]idEntry
+
+	// next due time of each maintenance task, in manual mode
+	dueLock      sync.Mutex
+	stabilizeDue time.Time
+	monitorDue   time.Time
+	fingerDue    time.Time
+	entropyDue   time.Time
+
+	versionLock sync.Mutex
+	lastVersion int64
+	fingerIdx   int
+
+	// successors and predecessors found down, see Rejoin
+	lost lostSet
 }
 
-func (n *chordBaseNode) initialize(ip Address) {
-	n.serverInit(ip, "ChordService", n)
+func (n *chordBaseNode) initialize(ip Address, cfg Config, logs *log.Logger) {
+	n.cfg = cfg
+	n.serverInit(ip, "ChordService", n, cfg, logs)
+	n.identityInit()
 	n.storeInit()
+	n.succList = make([]Address, cfg.SuccListLen)
+	n.finger = make([]finger, cfg.M)
+}
+
+// entry of the finger table, the ID of the node is kept along as it is
+// compared at every hop of a lookup
+type finger struct {
+	addr Address
+	id   Identifer
+}
+
+// the finger entry of the node at ADDR, left empty if the node does not
+// prove its identity while the nodes have ones
+func (n *chordBaseNode) fingerOf(addr Address) finger {
+	if addr == NIL {
+		return finger{}
+	}
+	if n.cfg.Identity != nil && addr != n.addr {
+		id, ok := n.resolve(addr)
+		if !ok {
+			return finger{}
+		}
+		return finger{addr: addr, id: id}
+	}
+	return finger{addr: addr, id: n.idOf(addr)}
+}
+
+// identifier of KEY on the ring of the node
+func (n *chordBaseNode) hash(key string) Identifer {
+	return n.cfg.hash(key)
+}
+
+// start of the x-th finger interval
+func (n *chordBaseNode) start(x int) Identifer {
+	return n.self.AddPow2(x).Trunc(n.cfg.M)
 }
 
 func (n *chordBaseNode) reset() {
-	n.storeReset()
+	if err := n.storeReset(); err != nil {
+		n.errLogger(err).Error("reopen storage failed")
+	}
+	n.poolReset()
+	n.detector.Reset()
 	n.pred = NIL
-	n.succList = [succListLen]Address{}
-	n.finger = [M]Address{}
+	n.succLock.Lock()
+	for i := range n.succList {
+		n.succList[i] = NIL
+	}
+	n.succLock.Unlock()
+	n.fingerLock.Lock()
+	for i := range n.finger {
+		n.finger[i] = finger{}
+	}
+	n.fingerLock.Unlock()
+	n.replicas = nil
+	n.lost.clear()
 }
 
 func (n *chordBaseNode) GetPredecessor(_ string, reply *string) error {
@@ -40,12 +119,11 @@ func (n *chordBaseNode) GetPredecessor(_ string, reply *string) error {
 	return nil
 }
 
-func (n *chordBaseNode) GetSuccList(_ string, reply *[succListLen]string) error {
+func (n *chordBaseNode) GetSuccList(_ string, reply *[]Address) error {
 	n.succLock.RLock()
 	defer n.succLock.RUnlock()
-	for i := 0; i < succListLen; i++ {
-		(*reply)[i] = n.succList[i]
-	}
+	*reply = make([]Address, len(n.succList))
+	copy(*reply, n.succList)
 	return nil
 }
 
@@ -53,30 +131,38 @@ func (n *chordBaseNode) GetSuccessor(_ string, reply *string) error {
 	n.succLock.RLock()
 	defer n.succLock.RUnlock()
 	for _, succ := range n.succList {
-		if n.ping(succ) {
+		if n.alive(succ) {
 			*reply = succ
 			return nil
 		}
+		n.lose(succ)
 	}
-	errLogger(n.addr, nil).Error("no available successor in the list")
+	n.errLogger(nil).Error("no available successor in the list")
 	// logrus.Errorf("[%s] no available successor in the list", n.addr)
 	*reply = NIL
 	return errors.New("no available successor")
 }
 
 func (n *chordBaseNode) UpdateSuccessor(succ Address, _ *string) error {
+	entry := n.fingerOf(succ)
 	n.fingerLock.Lock()
-	n.finger[0] = succ
+	n.finger[0] = entry
 	n.fingerLock.Unlock()
 	n.succLock.Lock()
 	n.succList[0] = succ
 	n.succLock.Unlock()
 	if succ != n.addr {
-		list := [succListLen]Address{}
+		var list []Address
 		err := n.call(succ, "ChordService", "GetSuccList", NIL, &list)
 		if err == nil {
 			n.succLock.Lock()
-			copy(n.succList[1:], list[:])
+			// the list of the successor may be of another length
+			for i := 1; i < len(n.succList); i++ {
+				n.succList[i] = NIL
+				if i-1 < len(list) {
+					n.succList[i] = list[i-1]
+				}
+			}
 			n.succLock.Unlock()
 		}
 	}
@@ -90,71 +176,81 @@ func (n *chordBaseNode) UpdatePredecessor(pred Address, _ *string) error {
 	return nil
 }
 
+// called when the predecessor is found offline, take over the keys
+// between the new predecessor PRED and the node from the backup
 func (n *chordBaseNode) TransferQuit(pred Address, _ *string) error {
-	var succ Address
-	err := n.GetSuccessor(NIL, &succ)
+	lower := n.idOf(pred)
+	filter := func(id string) bool {
+		return !contain(n.hash(id), lower, n.self, "(]")
+	}
+	temp := make(StoreType)
+	err := n.FilterBackup(filter, &temp)
 	if err != nil {
-		errLogger(n.addr, err).Error("transfer data after quit failed")
+		n.errLogger(err).Error("transfer data after quit failed")
 		// logrus.Errorf("[%s] , error message %v", n.addr, err)
 		return err
 	}
-	n.backupLock.RLock()
-	n.AppendData(n.backup, nil)
-	n.backupLock.RUnlock()
-	n.dataLock.RLock()
-	err = n.call(succ, "ChordService", "SetBackup", n.data, nil)
-	n.dataLock.RUnlock()
+	n.AppendData(temp, nil)
+	err = n.spreadReplica(temp)
 	if err != nil {
-		logger(n.addr).Warn("transfer data after quit warning")
+		n.logger().Warn("transfer data after quit warning")
 		// logrus.Warnf("[%s] transfer data after quit warning", n.addr)
 	}
-	n.ClearBackup(NIL, nil)
-	n.backupLock.Lock()
-	err = n.call(pred, "ChordService", "CopyData", NIL, &n.backup)
-	n.backupLock.Unlock()
-	if err != nil {
-		logger(n.addr).Warn("transfer data after quit warning")
-		// logrus.Warnf("[%s] transfer data after quit warning", n.addr)
+	if pred != n.addr {
+		temp = make(StoreType)
+		err = n.call(pred, "ChordService", "CopyData", NIL, &temp)
+		if err != nil {
+			n.logger().Warn("transfer data after quit warning")
+			// logrus.Warnf("[%s] transfer data after quit warning", n.addr)
+		}
+		n.AppendBackup(temp, nil)
 	}
 	return nil
 }
 
+// called when PRED joins right before the node, hand over the keys
+// between the old predecessor and PRED to it
 func (n *chordBaseNode) TransferJoin(pred Address, _ *string) error {
-	var succ Address
-	err := n.GetSuccessor(NIL, &succ)
-	if err != nil {
-		errLogger(n.addr, err).Error("transfer data after quit failed")
-		// logrus.Errorf("[%s] transfer data after quit failed, error message %v", n.addr, err)
-		return err
+	if !n.verified(pred) {
+		return ErrIdentity
 	}
-	n.backupLock.RLock()
-	err = n.call(pred, "ChordService", "SetBackup", n.backup, nil)
-	n.backupLock.RUnlock()
+	// predecessors of the joining node are the same as ours
+	temp := make(StoreType)
+	n.CopyBackup(NIL, &temp)
+	err := n.call(pred, "ChordService", "SetBackup", temp, nil)
 	if err != nil {
-		logger(n.addr).Warn("transfer data after join warning")
+		n.logger().Warn("transfer data after join warning")
 		// logrus.Warnf("[%s] transfer data after join warning", n.addr)
 	}
+	lower := n.idOf(pred)
 	filter := func(id string) bool {
-		return contain(hash(id), hash(pred), hash(n.addr), "(]")
+		return contain(n.hash(id), lower, n.self, "(]")
 	}
-	temp := make(StoreType)
+	temp = make(StoreType)
 	err = n.FilterData(filter, &temp)
 	if err != nil {
-		errLogger(n.addr, err).Errorf("transfer data after join warning")
+		n.errLogger(err).Errorf("transfer data after join warning")
 		// logrus.Errorf("[%s] transfer data after join warning, error message %v", n.addr, err)
 	}
 	err = n.call(pred, "ChordService", "SetData", temp, nil)
 	if err != nil {
-		logger(n.addr).Warn("transfer data after join warnin")
+		n.logger().Warn("transfer data after join warning")
 		// logrus.Warnf("[%s] transfer data after join warning", n.addr)
 	}
-	n.SetBackup(temp, nil)
-	n.dataLock.RLock()
-	err = n.call(succ, "ChordService", "SetBackup", n.data, nil)
-	n.dataLock.RUnlock()
-	if err != nil {
-		logger(n.addr).Warn("transfer data after join warning")
-		// logrus.Warnf("[%s] transfer data after join warning", n.addr)
+	n.AppendBackup(temp, nil)
+	// the last replica of ours no longer keeps a copy of the moved keys
+	var targets []Address
+	n.GetReplicas(NIL, &targets)
+	if len(targets) == n.cfg.ReplicaNum && len(temp) > 0 {
+		keys := make([]KeyType, 0, len(temp))
+		for k := range temp {
+			keys = append(keys, k)
+		}
+		err = n.call(targets[n.cfg.ReplicaNum-1], "ChordService", "DropBackup", keys, nil)
+		if err != nil {
+			n.logger().Warn("transfer data after join warning")
+			// logrus.Warnf("[%s] transfer data after join warning", n.addr)
+		}
 	}
 	return nil
 }
@@ -162,42 +258,85 @@ func (n *chordBaseNode) TransferJoin(pred Address, _ *string) error {
 func (n *chordBaseNode) ClosestPrecedingFinger(id Identifer, reply *string) error {
 	n.fingerLock.RLock()
 	defer n.fingerLock.RUnlock()
-	for i := M - 1; i >= 0; i-- {
-		if n.ping(n.finger[i]) && contain(hash(n.finger[i]), hash(n.addr), id, "()") {
-			*reply = n.finger[i]
+	for i := len(n.finger) - 1; i >= 0; i-- {
+		f := n.finger[i]
+		if f.addr != NIL && contain(f.id, n.self, id, "()") && n.alive(f.addr) {
+			*reply = f.addr
 			return nil
 		}
 	}
-	logger(n.addr).Info("successor failed")
+	n.logger().Info("successor failed")
 	// logrus.Infof("[%s] successor failed", n.addr)
 	err := n.GetSuccessor(NIL, reply)
 	return err
 }
 
 func (n *chordBaseNode) FindSuccessor(id Identifer, reply *string) error {
+	var r FindReply
+	err := n.findSuccessor(context.Background(), id, 0, &r)
+	*reply = r.Succ
+	return err
+}
+
+// FindRequest is a recursive lookup for the successor of ID, forwarded
+// HOPS times so far
+type FindRequest struct {
+	ID   Identifer
+	Hops int
+}
+
+// FindReply is the successor found by a recursive lookup, and the number
+// of nodes the lookup went through
+type FindReply struct {
+	Succ Address
+	Hops int
+}
+
+func (n *chordBaseNode) ForwardFind(req FindRequest, reply *FindReply) error {
+	return n.findSuccessor(context.Background(), req.ID, req.Hops, reply)
+}
+
+// recursive lookup for the successor of ID reaching the node after HOPS
+// forwards, it fails once the path would grow longer than MaxHops nodes
+func (n *chordBaseNode) findSuccessor(ctx context.Context, id Identifer, hops int, reply *FindReply) error {
+	if ctx.Err() != nil {
+		return dht.FromContext(ctx.Err())
+	}
 	var succ, next Address
 	err := n.GetSuccessor(NIL, &succ)
-	if err == nil && contain(id, hash(n.addr), hash(succ), "(]") {
-		logger(n.addr).WithField("target", id.String()).
+	if err == nil && contain(id, n.self, n.idOf(succ), "(]") {
+		n.logger().WithField("target", id.String()).
 			Info("find successor succeded")
 		// logrus.Infof("[%s] find successor of %v succeeded", n.addr, id.String())
-		*reply = succ
+		*reply = FindReply{Succ: succ, Hops: hops + 1}
 		return nil
 	}
-	err = n.ClosestPrecedingFinger(id, &next)
-	if err != nil {
-		errLogger(n.addr, err).WithField("target", id.String()).
-			Error("find successor of %v failed", id.String())
-		// logrus.Errorf("[%s] find successor of %v failed, error message %v", n.addr, id.String(), err)
-		return err
+	if hops+1 >= n.cfg.MaxHops {
+		n.errLogger(ErrHopLimit).WithField("target", id.String()).
+			Error("find successor failed")
+		return dht.ErrNoRoute
+	}
+	for {
+		err = n.ClosestPrecedingFinger(id, &next)
+		if err != nil {
+			n.errLogger(err).WithField("target", id.String()).
+				Error("find successor failed")
+			// logrus.Errorf("[%s] find successor of %v failed, error message %v", n.addr, id.String(), err)
+			return err
+		}
+		err = n.callCtx(ctx, next, "ChordService", "ForwardFind", FindRequest{ID: id, Hops: hops + 1}, reply)
+		// a hop gone since it was last heard of is taken as down by the
+		// failed call, the lookup goes around it
+		if err == nil || ctx.Err() != nil || n.alive(next) {
+			break
+		}
 	}
-	err = n.call(next, "ChordService", "FindSuccessor", id, reply)
 	if err != nil {
-		errLogger(n.addr, err).WithField("target", id.String()).
+		n.errLogger(err).WithField("target", id.String()).
 			Error("find successor failed")
 		// logrus.Errorf("[%s] find successor of %v failed, error message %v", n.addr, *id, err)
 	} else {
-		logger(n.addr).WithField("target", id.String()).
+		n.logger().WithField("target", id.String()).
 			Info("find successor succeeded")
 		// logrus.Infof("[%s] find successor of %v succeeded", n.addr, id.String())
 	}
@@ -208,47 +347,138 @@ func (n *chordBaseNode) Stablize(_ string, _ *string) error {
 	var succ, p Address
 	err := n.GetSuccessor(NIL, &succ)
 	if err != nil {
-		errLogger(n.addr, err).Error("stablize failed")
+		// the whole list is down, as for a node whose successors are all
+		// across a partition, the ring is found again from the nearest
+		// node left
+		succ = n.nearestAlive()
+	}
+	if succ == NIL {
+		stabilizeRounds.With(n.addr, "failed").Inc()
+		n.errLogger(err).Error("stablize failed")
 		// logrus.Errorf("[%s] stablize failed, error message %v", n.addr, err)
 		return err
 	}
 	err = n.call(succ, "ChordService", "GetPredecessor", NIL, &p)
-	if err == nil && n.ping(p) && contain(hash(p), hash(n.addr), hash(succ), "()") {
-		logger(n.addr).Info("successor updated")
+	if err == nil && contain(n.idOf(p), n.self, n.idOf(succ), "()") && n.alive(p) {
+		n.logger().Info("successor updated")
 		// logrus.Infof("[%s] successor updated", n.addr)
 		succ = p
 	}
 	n.UpdateSuccessor(succ, nil)
 	n.call(succ, "ChordService", "Notify", n.addr, nil)
+	stabilizeRounds.With(n.addr, "ok").Inc()
 	return nil
 }
 
+// the first of the fingers and the predecessor found alive, nearest to
+// the node first
+func (n *chordBaseNode) nearestAlive() Address {
+	var pred Address
+	n.GetPredecessor(NIL, &pred)
+	n.fingerLock.RLock()
+	candidates := make([]Address, 0, len(n.finger)+1)
+	for _, f := range n.finger {
+		candidates = append(candidates, f.addr)
+	}
+	n.fingerLock.RUnlock()
+	seen := map[Address]bool{NIL: true, n.addr: true}
+	for _, addr := range append(candidates, pred) {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if n.alive(addr) {
+			return addr
+		}
+	}
+	return NIL
+}
+
 func (n *chordBaseNode) Notify(p Address, _ *string) error {
+	if !n.verified(p) {
+		return ErrIdentity
+	}
+	// a node notifying is online
+	n.detector.Success(p)
 	var pred Address
 	n.GetPredecessor(NIL, &pred)
-	if !n.ping(pred) {
+	// a predecessor quitting notifies with its last heartbeats still fresh,
+	// so it is pinged rather than taken from the failure detector
+	if !n.ping(pred) || !n.verified(pred) {
+		n.lose(pred)
 		n.UpdatePredecessor(p, nil)
 		n.TransferQuit(p, nil)
 	} else {
-		if contain(hash(p), hash(pred), hash(n.addr), "()") {
+		if contain(n.idOf(p), n.idOf(pred), n.self, "()") {
 			n.UpdatePredecessor(p, nil)
+			n.handOver(pred, p)
 		}
 	}
 	return nil
 }
 
+// hand the keys between PRED and the new predecessor P over to P, kept
+// in backup as the node is a replica of P. A node joining has taken them
+// already, the ones left are those written in between or those of a ring
+// merged with the one of P
+func (n *chordBaseNode) handOver(pred, p Address) {
+	lower, upper := n.idOf(pred), n.idOf(p)
+	temp := make(StoreType)
+	err := n.FilterData(func(k string) bool {
+		return !contain(n.hash(k), lower, upper, "(]")
+	}, &temp)
+	if len(temp) == 0 {
+		return
+	}
+	if err == nil {
+		err = n.call(p, "ChordService", "AppendData", temp, nil)
+	}
+	if err != nil {
+		n.errLogger(err).WithField("target", p).Warn("hand over data warning")
+		n.AppendData(temp, nil)
+		return
+	}
+	n.AppendBackup(temp, nil)
+}
+
 func (n *chordBaseNode) FixFinger(x int, _ *string) error {
-	var next Address
-	err := n.FindSuccessor(getStart(n.addr, x), &next)
+	next, err := n.locate(context.Background(), n.start(x))
+	fixFingerRounds.With(n.addr, outcome(err)).Inc()
 	if err == nil {
+		entry := n.fingerOf(next)
 		n.fingerLock.Lock()
 		defer n.fingerLock.Unlock()
-		n.finger[x] = next
+		n.finger[x] = entry
 	}
 	return nil
 }
 
+// send heartbeats to the successors and the predecessor, which the
+// routing relies on the most
+func (n *chordBaseNode) Monitor(_ string, _ *string) error {
+	var targets []Address
+	n.succLock.RLock()
+	for _, succ := range n.succList {
+		if succ != NIL && succ != n.addr && !inList(succ, targets) {
+			targets = append(targets, succ)
+		}
+	}
+	n.succLock.RUnlock()
+	n.predLock.RLock()
+	if n.pred != NIL && n.pred != n.addr && !inList(n.pred, targets) {
+		targets = append(targets, n.pred)
+	}
+	n.predLock.RUnlock()
+	n.heartbeat(targets)
+	return nil
+}
+
 func (n *chordBaseNode) maintain() {
+	if n.cfg.Manual {
+		// left to the caller of Maintain
+		return
+	}
+	clk := n.cfg.Clock
 	go func() {
 		for {
 			select {
@@ -256,8 +486,32 @@ func (n *chordBaseNode) maintain() {
 				return
 			default:
 				n.Stablize(NIL, nil)
+				n.FixReplica(NIL, nil)
 			}
-			time.Sleep(stablizePauseTime)
+			clk.Sleep(n.cfg.StabilizeInterval)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-n.quitMsg:
+				return
+			default:
+				n.Monitor(NIL, nil)
+			}
+			clk.Sleep(n.cfg.HeartbeatInterval)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-n.quitMsg:
+				return
+			default:
+				n.AntiEntropy(NIL, nil)
+				n.Rejoin(NIL, nil)
+			}
+			clk.Sleep(n.cfg.AntiEntropyInterval)
 		}
 	}()
 	go func() {
@@ -268,73 +522,160 @@ func (n *chordBaseNode) maintain() {
 				return
 			default:
 				n.FixFinger(idx, nil)
-				idx = (idx + 1) % M
+				idx = (idx + 1) % n.cfg.M
 			}
-			time.Sleep(fixfingerPauseTime)
+			clk.Sleep(n.cfg.FixFingerInterval)
 		}
 	}()
 }
 
+// run the maintenance tasks due by the clock of the node, one after
+// another in the calling goroutine, in manual mode
+func (n *chordBaseNode) round() {
+	if !n.onRing {
+		return
+	}
+	n.dueLock.Lock()
+	defer n.dueLock.Unlock()
+	now := n.cfg.Clock.Now()
+	if !now.Before(n.stabilizeDue) {
+		n.Stablize(NIL, nil)
+		n.FixReplica(NIL, nil)
+		n.stabilizeDue = now.Add(n.cfg.StabilizeInterval)
+	}
+	if !now.Before(n.monitorDue) {
+		n.Monitor(NIL, nil)
+		n.monitorDue = now.Add(n.cfg.HeartbeatInterval)
+	}
+	if !now.Before(n.fingerDue) {
+		n.FixFinger(n.fingerIdx, nil)
+		n.fingerIdx = (n.fingerIdx + 1) % n.cfg.M
+		n.fingerDue = now.Add(n.cfg.FixFingerInterval)
+	}
+	if !now.Before(n.entropyDue) {
+		n.AntiEntropy(NIL, nil)
+		n.Rejoin(NIL, nil)
+		n.entropyDue = now.Add(n.cfg.AntiEntropyInterval)
+	}
+}
+
 func (n *chordBaseNode) initFingerTable(succ Address) {
 	n.fingerLock.Lock()
 	defer n.fingerLock.Unlock()
-	n.finger[0] = succ
-	for i := 1; i < M; i++ {
-		if contain(getStart(n.addr, i), hash(n.addr), hash(n.finger[i-1]), "[)") {
+	n.finger[0] = n.fingerOf(succ)
+	for i := 1; i < n.cfg.M; i++ {
+		if contain(n.start(i), n.self, n.finger[i-1].id, "[)") {
 			n.finger[i] = n.finger[i-1]
 		} else {
-			n.call(succ, "ChordService", "FindSuccessor", getStart(n.addr, i), &n.finger[i])
+			var next Address
+			n.call(succ, "ChordService", "FindSuccessor", n.start(i), &next)
+			n.finger[i] = n.fingerOf(next)
 		}
 	}
 }
 
 func (n *chordBaseNode) create() bool {
 	if n.onRing {
-		logger(n.addr).Info("create failed, node already in the network")
+		n.logger().Info("create failed, node already in the network")
 		// logrus.Infof("[%s] create failed, node have joined", n.addr)
 		return false
 	}
+	if n.recovered {
+		// the node is alone in the new network, all the keys belong to it
+		temp := make(StoreType)
+		n.FilterBackup(func(string) bool { return false }, &temp)
+		n.AppendData(temp, nil)
+		n.recovered = false
+	}
 	n.UpdateSuccessor(n.addr, nil)
 	n.UpdatePredecessor(n.addr, nil)
-	for i := 0; i < M; i++ {
-		n.finger[i] = n.addr
+	for i := 0; i < n.cfg.M; i++ {
+		n.finger[i] = finger{addr: n.addr, id: n.self}
 	}
 	n.onRing = true
+	n.exportStores()
 	n.maintain()
 	return true
 }
 
 func (n *chordBaseNode) join(address Address) bool {
 	if n.onRing {
-		logger(n.addr).Info("join failed, node already in the network")
+		n.logger().Info("join failed, node already in the network")
 		// logrus.Infof("[%s] join failed, node have onRing", n.addr)
 		return false
 	}
-	var succ Address
-	n.call(address, "ChordService", "FindSuccessor", hash(n.addr), &succ)
+	var (
+		succ  Address
+		stale StoreType
+	)
+	if n.recovered {
+		// data reloaded from disk is overwritten by the transfer below,
+		// keep it aside to reconcile with the network later
+		stale = make(StoreType)
+		n.CopyBackup(NIL, &stale)
+		n.CopyData(NIL, &stale)
+		n.recovered = false
+	}
+	n.call(address, "ChordService", "FindSuccessor", n.self, &succ)
 	if succ != n.addr {
 		n.call(succ, "ChordService", "TransferJoin", n.addr, nil)
 	}
-	list := [succListLen]string{}
-	n.call(succ, "ChordService", "GetSuccList", NIL, &list)
 	n.UpdateSuccessor(succ, nil)
 	n.UpdatePredecessor(NIL, nil)
 	n.initFingerTable(succ)
 	n.onRing = true
+	n.exportStores()
 	n.maintain()
+	if stale != nil {
+		go n.reconcile(stale)
+	}
 	return true
 }
 
+// put back the reloaded keys that the network has lost while the node was
+// offline, keys present in the network are taken as more recent
+func (n *chordBaseNode) reconcile(stale StoreType) {
+	// wait for the network to take the node in, otherwise the keys
+	// in its range would be put to its successor
+	for {
+		var pred Address
+		n.GetPredecessor(NIL, &pred)
+		if pred != NIL {
+			break
+		}
+		select {
+		case <-n.quitMsg:
+			return
+		case <-n.cfg.Clock.After(n.cfg.StabilizeInterval):
+		}
+	}
+	cnt := 0
+	for k, v := range stale {
+		_, err := n.get(context.Background(), k)
+		if !errors.Is(err, dht.ErrNotFound) {
+			if err != nil {
+				n.errLogger(err).WithField("key", k).Warn("reconcile warning")
+			}
+			continue
+		}
+		// the version it was written with is kept
+		if n.putRecord(context.Background(), k, decodeRecord(v), DefaultLevel) == nil {
+			cnt++
+		}
+	}
+	n.logger().WithField("restored", cnt).Info("reconcile finished")
+}
+
 func (n *chordBaseNode) quit() {
 	if !n.onRing {
-		logger(n.addr).Info("quit failed, node already left the network")
+		n.logger().Info("quit failed, node already left the network")
 		// logrus.Warnf("[%s] node have quited", n.addr)
 		return
 	}
 	var pred, succ Address
 	err := n.GetPredecessor(NIL, &pred)
 	if err != nil {
-		errLogger(n.addr, err).Error("unexpected quit status")
+		n.errLogger(err).Error("unexpected quit status")
 		// logrus.Warnf("[%s] quit warning, error message %v", n.addr, err)
 	}
 	n.shutdown(maintainerNum)
@@ -342,110 +683,198 @@ func (n *chordBaseNode) quit() {
 	if err == nil {
 		n.call(succ, "ChordService", "Notify", pred, nil)
 	}
+	// the data has been taken over by the successor
+	n.storePurge()
+	n.unexportStores()
 	n.reset()
 }
 
 func (n *chordBaseNode) forceQuit() {
 	if !n.onRing {
-		logger(n.addr).Info("quit failed, node already left the network")
+		n.logger().Info("quit failed, node already left the network")
 		// logrus.Warnf("[%s] node have quited", n.addr)
 		return
 	}
 	n.shutdown(maintainerNum)
+	n.unexportStores()
 	n.reset()
 }
 
-func (n *chordBaseNode) get(key KeyType) (bool, string) {
+func (n *chordBaseNode) get(ctx context.Context, key KeyType) (ValueType, error) {
+	return n.getLevel(ctx, key, DefaultLevel)
+}
+
+// read KEY from LEVEL of its copies, a signed value is stripped of its
+// signature
+func (n *chordBaseNode) getLevel(ctx context.Context, key KeyType, level Consistency) (ValueType, error) {
+	v, err := n.getSigned(ctx, key, level)
+	return v.Data, err
+}
+
+// read KEY from LEVEL of its copies, checking the signature of the value
+func (n *chordBaseNode) getSigned(ctx context.Context, key KeyType, level Consistency) (identity.Value, error) {
+	rec, err := n.getRecord(ctx, key, level)
+	if err != nil {
+		return identity.Value{}, err
+	}
+	// the copies read have been checked already
+	v, _ := openRecord(key, rec)
+	return v, nil
+}
+
+// read KEY from LEVEL of its copies, at level One the owner alone is asked
+// unless it misses the key or can not be reached
+func (n *chordBaseNode) getRecord(ctx context.Context, key KeyType, level Consistency) (record, error) {
 	var (
 		succ      Address
+		copies    []copyReply
 		err       error
-		getLogger = logger(n.addr).WithField("key", key)
+		getLogger = n.logger().WithField("key", key)
 	)
-	err = n.FindSuccessor(hash(key), &succ)
-	if err != nil {
-		getLogger.WithError(err).Error("get key failed")
-		// logrus.Errorf("[%s] get key %s failed, error message %v", n.addr, key, err)
-		return false, NIL
+	if level == DefaultLevel {
+		level = n.cfg.ReadLevel
 	}
-	var val Address
-	err = n.call(succ, "ChordService", "GetData", key, &val)
+	succ, err = n.locate(ctx, n.hash(key))
 	if err != nil {
 		getLogger.WithError(err).Error("get key failed")
-		// logrus.Errorf("[%s] get key %s failed, error message %v", n.addr, key, err)
-		return false, NIL
+		return record{}, dht.Wrap(ctx, "get", key, dht.ErrNoRoute, err)
+	}
+	if level == One {
+		copies, err = n.readCopies(ctx, key, succ, []Address{succ}, 1)
+		if len(copies) == 0 || !copies[0].found {
+			if ctx.Err() == nil {
+				// the owner may not have the data yet or be gone, while
+				// its replicas still keep it
+				replicas := n.replicasOf(ctx, succ)
+				more, _ := n.readCopies(ctx, key, succ, replicas, len(replicas))
+				_, found := newest(more)
+				readFallbacks.With(n.addr, hitOrMiss(found)).Inc()
+				copies = append(copies, more...)
+			}
+		}
+	} else {
+		targets := append([]Address{succ}, n.replicasOf(ctx, succ)...)
+		need := level.need(len(targets))
+		copies, err = n.readCopies(ctx, key, succ, targets, need)
+		if len(copies) < need {
+			qerr := &QuorumError{Acks: len(copies), Need: need, N: len(targets)}
+			for _, c := range copies {
+				qerr.Owner = qerr.Owner || c.target == succ
+			}
+			getLogger.WithError(qerr).WithField("cause", err).Error("get key failed")
+			return record{}, dht.Wrap(ctx, "get", key, dht.ErrQuorum, qerr)
+		}
+	}
+	rec, found := newest(copies)
+	if !found {
+		if len(copies) == 0 {
+			getLogger.WithError(err).Error("get key failed")
+			return record{}, dht.Wrap(ctx, "get", key, dht.ErrNoRoute, err)
+		}
+		getLogger.Info("get key not found")
+		return record{}, dht.Wrap(ctx, "get", key, dht.ErrNotFound, nil)
 	}
-	getLogger.WithField("value", val).Info("get key succeeded")
-	// logrus.Infof("[%s] get key %s successed, value %s", n.addr, key, val)
-	return true, val
+	n.readRepair(ctx, key, succ, rec, copies)
+	if rec.Deleted {
+		getLogger.WithField("version", rec.Version).Info("get key deleted")
+		return record{}, dht.Wrap(ctx, "get", key, dht.ErrNotFound, nil)
+	}
+	getLogger.WithField("value", rec.Value).Info("get key succeeded")
+	return rec, nil
+}
+
+func (n *chordBaseNode) put(ctx context.Context, key KeyType, val ValueType) error {
+	return n.putRecord(ctx, key, record{Version: n.nextVersion(), Value: val}, DefaultLevel)
 }
 
-func (n *chordBaseNode) put(key KeyType, val ValueType) bool {
+// write REC of KEY to all of its copies, it succeeds once LEVEL of them
+// are written
+func (n *chordBaseNode) putRecord(ctx context.Context, key KeyType, rec record, level Consistency) error {
 	var (
 		succ      Address
-		next      Address
 		err       error
-		putLogger = logger(n.addr).
-				WithFields(log.Fields{"key": key, "value": val})
+		putLogger = n.logger().
+				WithFields(log.Fields{"key": key, "value": rec.Value, "version": rec.Version})
 	)
-	err = n.FindSuccessor(hash(key), &succ)
-	if err != nil {
-		putLogger.WithError(err).Error("put data failed")
-		// logrus.Errorf("[%s] put key-val pair (%s, %s) failed, error message %v", n.addr, key, val, err)
-		return false
+	if level == DefaultLevel {
+		level = n.cfg.WriteLevel
 	}
-	err = n.call(succ, "ChordService", "PutData", DataPair{Key: key, Val: val}, nil)
+	succ, err = n.locate(ctx, n.hash(key))
 	if err != nil {
 		putLogger.WithError(err).Error("put data failed")
-		// logrus.Errorf("[%s] put key-val pair (%s, %s) in data failed, error message %v", n.addr, key, val, err)
-		return false
+		// logrus.Errorf("[%s] put key-val pair (%s, %s) failed, error message %v", n.addr, key, rec.Value, err)
+		return dht.Wrap(ctx, "put", key, dht.ErrNoRoute, err)
 	}
-	err = n.call(succ, "ChordService", "GetSuccessor", NIL, &next)
-	if err != nil {
-		putLogger.WithError(err).Error("put data in backup failed")
-		// logrus.Errorf("[%s] put key-val pair (%s, %s) in backup failed, error message %v", n.addr, key, val, err)
-		return false
+	targets := append([]Address{succ}, n.replicasOf(ctx, succ)...)
+	qerr := n.writeCopies(ctx, key, rec, succ, targets, level.need(len(targets)))
+	switch {
+	case qerr == nil:
+		return nil
+	case qerr.met():
+		// the level is met, the copies missing the value are left to
+		// anti-entropy
+		putLogger.WithError(qerr).Warn("put data in backup failed")
+		return dht.Wrap(ctx, "put", key, dht.ErrReplicaWrite, qerr)
 	}
-	err = n.call(next, "ChordService", "PutBackup", DataPair{Key: key, Val: val}, nil)
-	if err != nil {
-		putLogger.WithError(err).Error("put data in backup failed")
-		// logrus.Errorf("[%s] put key-val pair (%s, %s) in backup failed, error message %v", n.addr, key, val, err)
-		return false
+	putLogger.WithError(qerr).Error("put data failed")
+	if qerr.Refused > 0 {
+		return dht.Wrap(ctx, "put", key, dht.ErrUnauthorized, qerr)
 	}
-	return true
+	return dht.Wrap(ctx, "put", key, dht.ErrQuorum, qerr)
 }
 
-func (n *chordBaseNode) del(key KeyType) bool {
+func (n *chordBaseNode) del(ctx context.Context, key KeyType) error {
 	var (
 		succ      Address
-		next      Address
+		replicas  []Address
+		missing   bool
 		err       error
-		delLogger = logger(n.addr).WithField("key", key)
+		delLogger = n.logger().WithField("key", key)
 	)
-	err = n.FindSuccessor(hash(key), &succ)
+	succ, err = n.locate(ctx, n.hash(key))
 	if err != nil {
 		delLogger.WithError(err).Error("delete key failed")
 		// logrus.Errorf("[%s] delete key %s failed, error message: %v", n.addr, key, err)
-		return false
-	}
-	err = n.call(succ, "ChordService", "DeleteData", key, nil)
-	if err != nil {
+		return dht.Wrap(ctx, "delete", key, dht.ErrNoRoute, err)
+	}
+	// a tombstone is left in every copy, so that a copy missing it does
+	// not bring the key back through a read repair or anti-entropy
+	tomb := DataPair{Key: key, Val: record{Version: n.nextVersion(), Deleted: true}.encode()}
+	err = n.callCtx(ctx, succ, "ChordService", "DeleteData", tomb, nil)
+	if errors.Is(err, dht.ErrNotFound) {
+		// still clean up the replicas in case they are left behind
+		missing = true
+	} else if err != nil {
 		delLogger.WithError(err).Error("delete key in data failed")
 		// logrus.Errorf("[%s] delete key %s in data failed, error message: %v", n.addr, key, err)
-		return false
+		return dht.Wrap(ctx, "delete", key, dht.ErrNoRoute, err)
 	}
-	err = n.call(succ, "ChordService", "GetSuccessor", NIL, &next)
+	// the owner has the tombstone from here on, a replica missing it is
+	// reported apart and left to anti-entropy
+	err = n.callCtx(ctx, succ, "ChordService", "GetReplicas", NIL, &replicas)
 	if err != nil {
-		delLogger.WithError(err).Error("delete key in backup failed")
+		delLogger.WithError(err).Warn("delete key in backup failed")
 		// logrus.Errorf("[%s] delete key %s in backup failed, error message: %v", n.addr, key, err)
-		return false
+		return dht.Wrap(ctx, "delete", key, dht.ErrReplicaWrite, err)
+	}
+	var replicaErr error
+	for _, next := range replicas {
+		err = n.callCtx(ctx, next, "ChordService", "DeleteBackup", tomb, nil)
+		if err != nil {
+			delLogger.WithError(err).WithField("replica", next).
+				Warn("delete key in backup failed")
+			// logrus.Errorf("[%s] delete key %s in backup failed, error message: %v", n.addr, key, err)
+			replicaErr = err
+		}
 	}
-	err = n.call(next, "ChordService", "DeleteBackup", key, nil)
-	if err != nil {
-		delLogger.WithError(err).Error("delete key in backup failed")
-		// logrus.Errorf("[%s] delete key %s in backup failed, error message: %v", n.addr, key, err)
-		return false
+	if replicaErr != nil {
+		return dht.Wrap(ctx, "delete", key, dht.ErrReplicaWrite, replicaErr)
 	}
-	return true
+	if missing {
+		delLogger.Info("delete key not found")
+		return dht.Wrap(ctx, "delete", key, dht.ErrNotFound, nil)
+	}
+	return nil
 }
 
 // func (n *chordBaseNode) print() {
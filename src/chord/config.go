@@ -0,0 +1,175 @@
+package chord
+
+import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/ident"
+	"DHT-2022/src/identity"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/network"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config tunes the routing and maintenance of a node, the nodes of a
+// network must agree on M and Hash. Zero fields take the default values
+type Config struct {
+	// width of the identifiers, the ring has 2^M positions
+	M int
+	// hashes keys and addresses into identifiers, sha1 by default
+	Hash        ident.Hash
+	SuccListLen int
+	// successors keeping a copy of each key, at most SuccListLen
+	ReplicaNum int
+
+	StabilizeInterval time.Duration
+	FixFingerInterval time.Duration
+	HeartbeatInterval time.Duration
+	// how often the backups on the replicas are compared with the data
+	AntiEntropyInterval time.Duration
+	PingTimeout         time.Duration
+	DialTimeout         time.Duration
+
+	// where and how much the node logs, warnings to stderr by default
+	Log logging.Config
+
+	// carries the traffic of the node, TCP by default
+	Transport network.Transport
+	// secures the transport with TLS if set
+	TLS *network.TLSConfig
+	// key shared by the cluster signing every request, the requests not
+	// signed with it are rejected. Nothing is signed if empty
+	ClusterKey []byte
+	// keypair the ID of the node is derived from and its values are
+	// signed with, the ID is the hash of the address if nil. The nodes of
+	// a network must agree on whether they have identities
+	Identity *identity.Identity
+	// writes of values not signed by their publishers are refused
+	RequireSigned bool
+	// faults injected into the calls of the node, for tests
+	Faults *network.Faults
+
+	// copies of a key a get reads and a put writes at least, out of the
+	// owner and its ReplicaNum replicas, One and Quorum by default
+	ReadLevel  Consistency
+	WriteLevel Consistency
+
+	LookupMode LookupMode
+	// nodes a lookup may go through, in either mode
+	MaxHops int
+
+	// the real clock by default
+	Clock clock.Clock
+	// no maintenance goroutines are run, the tasks are run by calling
+	// Maintain instead, e.g. by a simulator driving the clock
+	Manual bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		M:                   M,
+		SuccListLen:         defaultSuccListLen,
+		ReplicaNum:          defaultReplicaNum,
+		StabilizeInterval:   defaultStabilizeInterval,
+		FixFingerInterval:   defaultFixFingerInterval,
+		HeartbeatInterval:   defaultHeartbeatInterval,
+		AntiEntropyInterval: defaultAntiEntropyInterval,
+		PingTimeout:         defaultPingTimeOut,
+		DialTimeout:         defaultDialTimeOut,
+		ReadLevel:           One,
+		WriteLevel:          Quorum,
+		LookupMode:          RecursiveLookup,
+		MaxHops:             defaultMaxHops,
+		Transport:           network.TCP{},
+		Clock:               clock.Real{},
+	}
+}
+
+func (c Config) withDefaults() Config {
+	def := DefaultConfig()
+	if c.M == 0 {
+		c.M = def.M
+	}
+	if c.SuccListLen == 0 {
+		c.SuccListLen = def.SuccListLen
+	}
+	if c.ReplicaNum == 0 {
+		c.ReplicaNum = def.ReplicaNum
+	}
+	if c.StabilizeInterval == 0 {
+		c.StabilizeInterval = def.StabilizeInterval
+	}
+	if c.FixFingerInterval == 0 {
+		c.FixFingerInterval = def.FixFingerInterval
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = def.HeartbeatInterval
+	}
+	if c.AntiEntropyInterval == 0 {
+		c.AntiEntropyInterval = def.AntiEntropyInterval
+	}
+	if c.PingTimeout == 0 {
+		c.PingTimeout = def.PingTimeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = def.DialTimeout
+	}
+	if c.Transport == nil {
+		c.Transport = def.Transport
+	}
+	if c.ReadLevel == DefaultLevel {
+		c.ReadLevel = def.ReadLevel
+	}
+	if c.WriteLevel == DefaultLevel {
+		c.WriteLevel = def.WriteLevel
+	}
+	if c.MaxHops == 0 {
+		c.MaxHops = def.MaxHops
+	}
+	if c.Clock == nil {
+		c.Clock = def.Clock
+	}
+	return c
+}
+
+// Validate reports the first field out of range, zero fields are taken
+// as valid since they take the default values
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	switch {
+	case c.M < 1 || c.M > M:
+		return fmt.Errorf("invalid config: M %d out of [1, %d]", c.M, M)
+	case c.SuccListLen < 1:
+		return fmt.Errorf("invalid config: SuccListLen %d less than 1", c.SuccListLen)
+	case c.ReplicaNum < 1 || c.ReplicaNum > c.SuccListLen:
+		return fmt.Errorf("invalid config: ReplicaNum %d out of [1, SuccListLen]", c.ReplicaNum)
+	case c.StabilizeInterval < 0 || c.FixFingerInterval < 0 || c.HeartbeatInterval < 0 ||
+		c.AntiEntropyInterval < 0:
+		return errors.New("invalid config: negative interval")
+	case c.PingTimeout < 0 || c.DialTimeout < 0:
+		return errors.New("invalid config: negative timeout")
+	case c.LookupMode != RecursiveLookup && c.LookupMode != IterativeLookup:
+		return fmt.Errorf("invalid config: unknown lookup mode %d", c.LookupMode)
+	case c.ReadLevel < All || c.WriteLevel < All:
+		return errors.New("invalid config: unknown consistency level")
+	case c.MaxHops < 1:
+		return fmt.Errorf("invalid config: MaxHops %d less than 1", c.MaxHops)
+	}
+	if err := c.Hash.Validate(); err != nil {
+		return err
+	}
+	if len(c.ClusterKey) > 0 && len(c.ClusterKey) < network.MinClusterKey {
+		return fmt.Errorf("invalid config: ClusterKey shorter than %d bytes", network.MinClusterKey)
+	}
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	return c.Log.Validate()
+}
+
+// identifier of KEY, its hash cut to M bits
+func (c *Config) hash(key string) Identifer {
+	return c.Hash.ID([]byte(key), c.M)
+}
@@ -1,7 +1,12 @@
 package chord
 
 import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/store"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type StoreType map[KeyType]ValueType
@@ -10,92 +15,151 @@ type FilterType func(string) bool
 type databaseNode struct {
 	dataLock   sync.RWMutex
 	backupLock sync.RWMutex
-	data       StoreType
-	backup     StoreType
+	data       *watchedEngine
+	backup     *watchedEngine
+	// gives the engines, kept to reopen them when the node leaves
+	opener store.Opener
+	// Merkle trees over data and backup, see antientropy.go
+	dataTrees   treeCache
+	backupTrees treeCache
+
+	recovered bool
 }
 
-func (n *databaseNode) storeInit() {
-	n.data = make(StoreType)
-	n.backup = make(StoreType)
+// watchedEngine counts the writes to an engine, so that what is built
+// from its content is kept until it changes
+type watchedEngine struct {
+	store.Engine
+	gen uint64
 }
 
-func (n *databaseNode) storeReset() {
-	n.data = make(StoreType)
-	n.backup = make(StoreType)
+func watch(e store.Engine) *watchedEngine {
+	return &watchedEngine{Engine: e}
 }
 
-func (n *databaseNode) PutData(p DataPair, _ *string) error {
-	n.dataLock.Lock()
-	defer n.dataLock.Unlock()
-	n.data[p.Key] = p.Val
-	return nil
+// number of writes so far
+func (w *watchedEngine) generation() uint64 {
+	return atomic.LoadUint64(&w.gen)
+}
+
+func (w *watchedEngine) Put(key, val string) error {
+	defer atomic.AddUint64(&w.gen, 1)
+	return w.Engine.Put(key, val)
+}
+
+func (w *watchedEngine) Delete(key string) error {
+	defer atomic.AddUint64(&w.gen, 1)
+	return w.Engine.Delete(key)
+}
+
+func (w *watchedEngine) Clear() error {
+	defer atomic.AddUint64(&w.gen, 1)
+	return w.Engine.Clear()
+}
+
+func (w *watchedEngine) Extract(match store.KeyFilter) (map[string]string, error) {
+	defer atomic.AddUint64(&w.gen, 1)
+	return w.Engine.Extract(match)
+}
+
+// nodeDir gives the per-node directory under DIR
+func nodeDir(dir string, addr Address) string {
+	return filepath.Join(dir, strings.NewReplacer(":", "_", "/", "_").Replace(addr))
+}
+
+func (n *databaseNode) storeInit() {
+	n.data = watch(store.NewMemory())
+	n.backup = watch(store.NewMemory())
+	n.opener = store.Memory()
 }
 
-func (n *databaseNode) PutBackup(p DataPair, _ *string) error {
+// replace the engines with the ones given by OPENER, data left by a previous
+// run of a durable engine is reloaded
+func (n *databaseNode) storeOpen(opener store.Opener) error {
+	data, err := opener("data")
+	if err != nil {
+		return err
+	}
+	backup, err := opener("backup")
+	if err != nil {
+		data.Close()
+		return err
+	}
+	n.dataLock.Lock()
 	n.backupLock.Lock()
-	defer n.backupLock.Unlock()
-	n.backup[p.Key] = p.Val
+	n.data.Close()
+	n.backup.Close()
+	n.data, n.backup, n.opener = watch(data), watch(backup), opener
+	n.recovered = data.Len() > 0 || backup.Len() > 0
+	n.backupLock.Unlock()
+	n.dataLock.Unlock()
 	return nil
 }
 
+// close the engines and open them again through the opener, what a
+// durable engine kept is reloaded unless it was purged
+func (n *databaseNode) storeReset() error {
+	n.data.Close()
+	n.backup.Close()
+	return n.storeOpen(n.opener)
+}
+
+// drop everything kept by the engines, used when the node leaves the
+// network normally and its data has been handed over
+func (n *databaseNode) storePurge() {
+	n.data.Drop()
+	n.backup.Drop()
+}
+
 func (n *databaseNode) GetData(k KeyType, v *ValueType) error {
 	n.dataLock.RLock()
 	defer n.dataLock.RUnlock()
-	*v = n.data[k]
+	var ok bool
+	if *v, ok = n.data.Get(k); !ok {
+		return dht.ErrNotFound
+	}
 	return nil
 }
 
 func (n *databaseNode) GetBackup(k KeyType, v *ValueType) error {
 	n.backupLock.RLock()
 	defer n.backupLock.RUnlock()
-	*v = n.backup[k]
-	return nil
-}
-
-func (n *databaseNode) SetData(mp StoreType, _ *string) error {
-	n.data = make(map[string]string)
-	for k, v := range mp {
-		n.data[k] = v
+	var ok bool
+	if *v, ok = n.backup.Get(k); !ok {
+		return dht.ErrNotFound
 	}
 	return nil
 }
 
-func (n *databaseNode) SetBackup(mp StoreType, _ *string) error {
-	n.backup = make(map[string]string)
-	for k, v := range mp {
-		n.backup[k] = v
-	}
-	return nil
-}
+// the deletes merge the tombstone P, DeleteData tells if no value was
+// stored under the key
 
-func (n *databaseNode) DeleteData(k KeyType, _ *string) error {
+func (n *databaseNode) DeleteData(p DataPair, _ *string) error {
 	n.dataLock.Lock()
 	defer n.dataLock.Unlock()
-	delete(n.data, k)
+	old, ok := n.data.Get(p.Key)
+	if err := mergeEngine(n.data, p.Key, p.Val); err != nil {
+		return err
+	}
+	if !ok || decodeRecord(old).Deleted {
+		return dht.ErrNotFound
+	}
 	return nil
 }
 
-func (n *databaseNode) DeleteBackup(k KeyType, _ *string) error {
+func (n *databaseNode) DeleteBackup(p DataPair, _ *string) error {
 	n.backupLock.Lock()
 	defer n.backupLock.Unlock()
-	delete(n.backup, k)
-	return nil
-}
-
-func (n *databaseNode) AppendData(mp StoreType, _ *string) error {
-	n.dataLock.Lock()
-	defer n.dataLock.Unlock()
-	for k, v := range mp {
-		n.data[k] = v
-	}
-	return nil
+	return mergeEngine(n.backup, p.Key, p.Val)
 }
 
-func (n *databaseNode) AppendBackup(mp StoreType, _ *string) error {
+func (n *databaseNode) DropBackup(keys []KeyType, _ *string) error {
 	n.backupLock.Lock()
 	defer n.backupLock.Unlock()
-	for k, v := range mp {
-		n.backup[k] = v
+	for _, k := range keys {
+		if err := n.backup.Delete(k); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -103,56 +167,69 @@ func (n *databaseNode) AppendBackup(mp StoreType, _ *string) error {
 func (n *databaseNode) FilterData(filter FilterType, res *StoreType) error {
 	n.dataLock.Lock()
 	defer n.dataLock.Unlock()
-	for k, v := range n.data {
-		if !filter(k) {
-			delete(n.data, k)
-			(*res)[k] = v
-		}
+	mp, err := n.data.Extract(func(k string) bool { return !filter(k) })
+	for k, v := range mp {
+		(*res)[k] = v
 	}
-	return nil
+	return err
 }
 
 func (n *databaseNode) FilterBackup(filter FilterType, res *StoreType) error {
 	n.backupLock.Lock()
 	defer n.backupLock.Unlock()
-	for k, v := range n.backup {
-		if !filter(k) {
-			delete(n.backup, k)
-			(*res)[k] = v
-		}
+	mp, err := n.backup.Extract(func(k string) bool { return !filter(k) })
+	for k, v := range mp {
+		(*res)[k] = v
 	}
-	return nil
+	return err
 }
 
 func (n *databaseNode) CopyData(_ string, mp *StoreType) error {
 	n.dataLock.RLock()
 	defer n.dataLock.RUnlock()
-	for k, v := range n.data {
+	n.data.ForEach(func(k, v string) bool {
 		(*mp)[k] = v
-	}
+		return true
+	})
 	return nil
 }
 
 func (n *databaseNode) CopyBackup(_ string, mp *StoreType) error {
 	n.backupLock.RLock()
 	defer n.backupLock.RUnlock()
-	for k, v := range n.backup {
+	n.backup.ForEach(func(k, v string) bool {
 		(*mp)[k] = v
-	}
+		return true
+	})
 	return nil
 }
 
 func (n *databaseNode) ClearData(_ string, _ *string) error {
 	n.dataLock.Lock()
 	defer n.dataLock.Unlock()
-	n.data = make(StoreType)
-	return nil
+	return n.data.Clear()
 }
 
 func (n *databaseNode) ClearBackup(_ string, _ *string) error {
 	n.backupLock.Lock()
 	defer n.backupLock.Unlock()
-	n.backup = make(StoreType)
+	return n.backup.Clear()
+}
+
+func setEngine(e store.Engine, mp StoreType) error {
+	if err := e.Clear(); err != nil {
+		return err
+	}
+	return appendEngine(e, mp)
+}
+
+// records of MP are merged into E, the newer of two records is kept
+func appendEngine(e store.Engine, mp StoreType) error {
+	for k, v := range mp {
+		if err := mergeEngine(e, k, v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
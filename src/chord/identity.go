@@ -0,0 +1,99 @@
+package chord
+
+import (
+	"DHT-2022/src/identity"
+	"errors"
+	"time"
+)
+
+// nodes with identities take their IDs from their public keys, a peer
+// proves the key it holds once asked and the ID is cached for identityTTL
+
+var ErrIdentity = errors.New("node identity not proved")
+
+type idEntry struct {
+	id      Identifer
+	checked time.Time
+}
+
+func (n *chordBaseNode) identityInit() {
+	n.self = n.hash(n.addr)
+	if id := n.cfg.Identity; id != nil {
+		n.self = n.cfg.Hash.ID(id.Public(), n.cfg.M)
+		n.proof = id.Prove(n.addr)
+	}
+	n.ids = make(map[Address]idEntry)
+}
+
+// GetIdentity replies the proof of the key the node holds
+func (n *chordBaseNode) GetIdentity(_ string, reply *identity.Proof) error {
+	if n.cfg.Identity == nil {
+		return identity.ErrNoIdentity
+	}
+	*reply = n.proof
+	return nil
+}
+
+// identifier of the node at ADDR. A peer not proving its identity while
+// the nodes have ones is given the hash of its address, it is kept out of
+// the routing anyway as it is never taken as alive
+func (n *chordBaseNode) idOf(addr Address) Identifer {
+	if addr == n.addr {
+		return n.self
+	}
+	if n.cfg.Identity != nil {
+		if id, ok := n.resolve(addr); ok {
+			return id
+		}
+	}
+	return n.hash(addr)
+}
+
+// ID proved by the node at ADDR, the one proved last is kept while the
+// node can not be reached
+func (n *chordBaseNode) resolve(addr Address) (Identifer, bool) {
+	if addr == NIL {
+		return Identifer{}, false
+	}
+	now := n.cfg.Clock.Now()
+	n.idLock.Lock()
+	e, cached := n.ids[addr]
+	n.idLock.Unlock()
+	if cached && now.Sub(e.checked) < identityTTL {
+		return e.id, true
+	}
+	if suspected, known := n.detector.Suspected(addr); known && suspected {
+		return e.id, cached
+	}
+	var proof identity.Proof
+	if err := n.call(addr, "ChordService", "GetIdentity", NIL, &proof); err != nil {
+		return e.id, cached
+	}
+	if !proof.Verify(addr) {
+		n.logger().WithField("peer", addr).Warn("peer failed to prove its identity")
+		identityFailures.With(n.addr).Inc()
+		n.idLock.Lock()
+		delete(n.ids, addr)
+		n.idLock.Unlock()
+		return Identifer{}, false
+	}
+	e = idEntry{id: n.cfg.Hash.ID(proof.Key, n.cfg.M), checked: now}
+	n.idLock.Lock()
+	n.ids[addr] = e
+	n.idLock.Unlock()
+	return e.id, true
+}
+
+// whether the node at ADDR has proved its identity, if the nodes have ones
+func (n *chordBaseNode) verified(addr Address) bool {
+	if n.cfg.Identity == nil || addr == n.addr {
+		return true
+	}
+	_, ok := n.resolve(addr)
+	return ok
+}
+
+// a peer is taken as alive only once it has proved its identity
+func (n *chordBaseNode) alive(addr Address) bool {
+	return n.networkNode.alive(addr) && n.verified(addr)
+}
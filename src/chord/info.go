@@ -0,0 +1,97 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// NodeInfo is a snapshot of the state of a node, for debugging
+type NodeInfo struct {
+	Addr     Address
+	ID       string
+	Online   bool
+	Pred     Address
+	SuccList []Address
+	Finger   []Finger
+	Replicas []Address
+
+	DataKeys   int
+	BackupKeys int
+}
+
+// Finger is an entry of the finger table, NODE is the successor of START
+type Finger struct {
+	Start string
+	Node  Address
+}
+
+func (n *chordBaseNode) GetNodeInfo(_ string, reply *NodeInfo) error {
+	info := NodeInfo{
+		Addr:   n.addr,
+		ID:     n.self.String(),
+		Online: n.onRing,
+	}
+	n.GetPredecessor(NIL, &info.Pred)
+	n.GetSuccList(NIL, &info.SuccList)
+	n.fingerLock.RLock()
+	info.Finger = make([]Finger, len(n.finger))
+	for i, f := range n.finger {
+		info.Finger[i] = Finger{Start: n.start(i).String(), Node: f.addr}
+	}
+	n.fingerLock.RUnlock()
+	n.replicaLock.Lock()
+	info.Replicas = append([]Address(nil), n.replicas...)
+	n.replicaLock.Unlock()
+	n.dataLock.RLock()
+	info.DataKeys = n.data.Len()
+	n.dataLock.RUnlock()
+	n.backupLock.RLock()
+	info.BackupKeys = n.backup.Len()
+	n.backupLock.RUnlock()
+	*reply = info
+	return nil
+}
+
+// String dumps the state of the node, runs of fingers pointing to the
+// same node are folded into one line
+func (i NodeInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "node %s id %s online %v\n", i.Addr, i.ID, i.Online)
+	fmt.Fprintf(&b, "  pred     %s\n", orNil(i.Pred))
+	for k, succ := range i.SuccList {
+		fmt.Fprintf(&b, "  succ[%d]  %s\n", k, orNil(succ))
+	}
+	for lo := 0; lo < len(i.Finger); {
+		hi := lo
+		for hi+1 < len(i.Finger) && i.Finger[hi+1].Node == i.Finger[lo].Node {
+			hi++
+		}
+		fmt.Fprintf(&b, "  finger[%d-%d] %s\n", lo, hi, orNil(i.Finger[lo].Node))
+		lo = hi + 1
+	}
+	fmt.Fprintf(&b, "  replicas %v\n", i.Replicas)
+	fmt.Fprintf(&b, "  keys     %d data, %d backup\n", i.DataKeys, i.BackupKeys)
+	return b.String()
+}
+
+func orNil(addr Address) string {
+	if addr == NIL {
+		return "<nil>"
+	}
+	return addr
+}
+
+// Info gives the state of the node
+func (n *ChordNode) Info() NodeInfo {
+	var info NodeInfo
+	n.base.GetNodeInfo(NIL, &info)
+	return info
+}
+
+// RemoteInfo asks the node at ADDR for its state
+func (n *ChordNode) RemoteInfo(ctx context.Context, addr string) (NodeInfo, error) {
+	var info NodeInfo
+	err := n.base.callCtx(ctx, addr, "ChordService", "GetNodeInfo", NIL, &info)
+	return info, err
+}
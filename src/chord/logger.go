@@ -1,21 +1,13 @@
 package chord
 
 import (
-	"os"
-
 	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	file, _ := os.Create("debug.log")
-	log.SetOutput(file)
-	log.SetLevel(log.WarnLevel)
-}
-
-func logger(addr Address) *log.Entry {
-	return log.WithField("addr", addr)
+func (n *networkNode) logger() *log.Entry {
+	return n.logs.WithField("addr", n.addr)
 }
 
-func errLogger(addr Address, err error) *log.Entry {
-	return log.WithField("addr", addr).WithError(err)
+func (n *networkNode) errLogger(err error) *log.Entry {
+	return n.logs.WithField("addr", n.addr).WithError(err)
 }
@@ -0,0 +1,145 @@
+package chord
+
+import (
+	"DHT-2022/src/dht"
+	"context"
+	"time"
+)
+
+// in recursive mode each hop forwards the lookup to the next one, in
+// iterative mode the node starting the lookup asks every hop in turn
+type LookupMode int
+
+const (
+	RecursiveLookup LookupMode = iota
+	IterativeLookup
+)
+
+// the errors of a lookup going nowhere match dht.ErrNoRoute as well, as
+// the ones of a recursive lookup do
+var (
+	ErrHopLimit     error = routeError("lookup hop limit exceeded")
+	ErrRoutingCycle error = routeError("lookup routing cycle")
+)
+
+type routeError string
+
+func (e routeError) Error() string { return string(e) }
+
+func (e routeError) Is(target error) bool {
+	return target == dht.ErrNoRoute
+}
+
+type Hop struct {
+	Addr    Address
+	Latency time.Duration
+}
+
+// LookupTrace describes an iterative lookup, PATH lists the nodes asked in
+// turn, starting with the node itself
+type LookupTrace struct {
+	Succ Address
+	Path []Hop
+}
+
+func (t LookupTrace) Hops() int {
+	return len(t.Path)
+}
+
+type NextHopReply struct {
+	Done bool
+	Node Address
+}
+
+// the successor of ID if it lies right after the node, the closest
+// preceding finger of ID otherwise
+func (n *chordBaseNode) NextHop(id Identifer, reply *NextHopReply) error {
+	var succ Address
+	err := n.GetSuccessor(NIL, &succ)
+	if err == nil && contain(id, n.self, n.idOf(succ), "(]") {
+		*reply = NextHopReply{Done: true, Node: succ}
+		return nil
+	}
+	reply.Done = false
+	return n.ClosestPrecedingFinger(id, &reply.Node)
+}
+
+// iterative lookup for the successor of ID, it fails when the path grows
+// longer than MaxHops or comes back to a node already asked
+func (n *chordBaseNode) lookup(ctx context.Context, id Identifer) (LookupTrace, error) {
+	var (
+		trace LookupTrace
+		visit = make(map[Address]bool)
+		cur   = n.addr
+		prev  Address
+	)
+	for {
+		if ctx.Err() != nil {
+			return trace, dht.FromContext(ctx.Err())
+		}
+		if len(trace.Path) >= n.cfg.MaxHops {
+			return trace, ErrHopLimit
+		}
+		if visit[cur] {
+			return trace, ErrRoutingCycle
+		}
+		visit[cur] = true
+		var (
+			reply NextHopReply
+			err   error
+			start = n.cfg.Clock.Now()
+		)
+		if cur == n.addr {
+			err = n.NextHop(id, &reply)
+		} else {
+			err = n.callCtx(ctx, cur, "ChordService", "NextHop", id, &reply)
+		}
+		trace.Path = append(trace.Path, Hop{Addr: cur, Latency: n.cfg.Clock.Since(start)})
+		if err != nil && cur != n.addr && ctx.Err() == nil && !n.alive(cur) {
+			// the hop is taken as down by the failed call, the one before
+			// it is asked again to go around it
+			delete(visit, prev)
+			cur = prev
+			continue
+		}
+		if err != nil {
+			return trace, err
+		}
+		if reply.Done {
+			trace.Succ = reply.Node
+			n.logger().WithField("target", id.String()).
+				WithField("hops", trace.Hops()).Info("lookup succeeded")
+			return trace, nil
+		}
+		prev, cur = cur, reply.Node
+	}
+}
+
+// find the successor of ID in the lookup mode of the node
+func (n *chordBaseNode) locate(ctx context.Context, id Identifer) (Address, error) {
+	var (
+		succ  Address
+		err   error
+		start = n.cfg.Clock.Now()
+	)
+	if n.cfg.LookupMode == IterativeLookup {
+		var trace LookupTrace
+		trace, err = n.lookup(ctx, id)
+		if err != nil {
+			n.errLogger(err).WithField("target", id.String()).
+				WithField("path", trace.Path).Error("lookup failed")
+		}
+		lookupHops.With(n.addr).Observe(float64(trace.Hops()))
+		succ = trace.Succ
+	} else {
+		var reply FindReply
+		err = n.findSuccessor(ctx, id, 0, &reply)
+		if err == nil {
+			lookupHops.With(n.addr).Observe(float64(reply.Hops))
+		}
+		succ = reply.Succ
+	}
+	lookupSeconds.With(n.addr, n.cfg.LookupMode.String(), outcome(err)).
+		ObserveDuration(n.cfg.Clock.Since(start))
+	return succ, err
+}
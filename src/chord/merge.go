@@ -0,0 +1,113 @@
+package chord
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// a partition splits the ring into rings of their own, each stabilizing
+// on its side and forgetting the nodes across. The nodes dropped as down
+// are kept aside for a while, and one of them is probed in every
+// anti-entropy round. A node answering again is asked for the successor
+// of the node on its ring, which is taken as the successor if it is
+// closer than the current one, stabilization merges the rings from there
+
+type lostSet struct {
+	lock  sync.Mutex
+	nodes map[Address]time.Time
+	// the node probed last, the next one is taken after it
+	last Address
+}
+
+// keep ADDR aside as lost at NOW, the oldest one is forgotten if too many
+func (s *lostSet) add(addr Address, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.nodes == nil {
+		s.nodes = make(map[Address]time.Time)
+	}
+	if _, ok := s.nodes[addr]; !ok && len(s.nodes) >= lostMaxNodes {
+		var oldest Address
+		for a, t := range s.nodes {
+			if oldest == NIL || t.Before(s.nodes[oldest]) {
+				oldest = a
+			}
+		}
+		delete(s.nodes, oldest)
+	}
+	s.nodes[addr] = now
+}
+
+func (s *lostSet) remove(addr Address) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.nodes, addr)
+}
+
+// the node to probe next, the nodes lost before BEFORE are forgotten
+func (s *lostSet) next(before time.Time) (Address, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	addrs := make([]Address, 0, len(s.nodes))
+	for a, t := range s.nodes {
+		if t.Before(before) {
+			delete(s.nodes, a)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return NIL, false
+	}
+	// in the order of the addresses, for the probes to be reproducible
+	sort.Strings(addrs)
+	i := sort.SearchStrings(addrs, s.last)
+	if i < len(addrs) && addrs[i] == s.last {
+		i++
+	}
+	s.last = addrs[i%len(addrs)]
+	return s.last, true
+}
+
+func (s *lostSet) clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodes, s.last = nil, NIL
+}
+
+// a successor or predecessor found down
+func (n *chordBaseNode) lose(addr Address) {
+	if addr != NIL && addr != n.addr {
+		n.lost.add(addr, n.cfg.Clock.Now())
+	}
+}
+
+// probe a node lost before, and take the successor it finds for the node
+// if it lies between the node and its successor
+func (n *chordBaseNode) Rejoin(_ string, _ *string) error {
+	addr, ok := n.lost.next(n.cfg.Clock.Now().Add(-lostForgetTime))
+	if !ok || !n.ping(addr) || !n.verified(addr) {
+		return nil
+	}
+	var succ, found Address
+	if err := n.GetSuccessor(NIL, &succ); err != nil {
+		return err
+	}
+	if err := n.call(addr, "ChordService", "FindSuccessor", n.self, &found); err != nil {
+		return err
+	}
+	if found == n.addr || found == succ {
+		// on the same ring
+		n.lost.remove(addr)
+		return nil
+	}
+	if contain(n.idOf(found), n.self, n.idOf(succ), "()") && n.alive(found) {
+		n.logger().WithField("lost", addr).WithField("successor", found).
+			Info("ring merged")
+		n.lost.remove(addr)
+		n.UpdateSuccessor(found, nil)
+		n.call(found, "ChordService", "Notify", n.addr, nil)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package chord
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+)
+
+// merkleTree sums up a set of keys for comparing it with the one of
+// another node. Keys are placed by the hex digits of their sha1, a node of
+// the tree is named by a prefix of these digits and the leaves by prefixes
+// of merkleDepth digits. Empty nodes have no digest
+type merkleTree struct {
+	digests map[string][]byte
+	leaves  map[string]StoreType
+}
+
+// leaf of the tree holding KEY
+func merkleLeaf(key KeyType) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])[:merkleDepth]
+}
+
+// children of the tree node PREFIX, none for a leaf
+func merkleChildren(prefix string) []string {
+	if len(prefix) >= merkleDepth {
+		return nil
+	}
+	ret := make([]string, 0, 16)
+	for _, c := range "0123456789abcdef" {
+		ret = append(ret, prefix+string(c))
+	}
+	return ret
+}
+
+// build the tree over the pairs given to ADD by EACH
+func buildMerkle(each func(add func(KeyType, ValueType))) *merkleTree {
+	t := &merkleTree{
+		digests: make(map[string][]byte),
+		leaves:  make(map[string]StoreType),
+	}
+	each(func(k KeyType, v ValueType) {
+		leaf := merkleLeaf(k)
+		if t.leaves[leaf] == nil {
+			t.leaves[leaf] = make(StoreType)
+		}
+		t.leaves[leaf][k] = v
+	})
+	inner := make(map[string]bool)
+	for leaf, mp := range t.leaves {
+		t.digests[leaf] = leafDigest(mp)
+		for i := 0; i < merkleDepth; i++ {
+			inner[leaf[:i]] = true
+		}
+	}
+	// deeper nodes first so that the children are summed up before
+	prefixes := make([]string, 0, len(inner))
+	for p := range inner {
+		prefixes = append(prefixes, p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	for _, p := range prefixes {
+		h := sha1.New()
+		for _, c := range merkleChildren(p) {
+			if d, ok := t.digests[c]; ok {
+				h.Write([]byte(c))
+				h.Write(d)
+			}
+		}
+		t.digests[p] = h.Sum(nil)
+	}
+	return t
+}
+
+func leafDigest(mp StoreType) []byte {
+	keys := make([]KeyType, 0, len(mp))
+	for k := range mp {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha1.New()
+	for _, k := range keys {
+		// lengths first so that no two sets of pairs write the same bytes
+		var size [16]byte
+		binary.BigEndian.PutUint64(size[:8], uint64(len(k)))
+		binary.BigEndian.PutUint64(size[8:], uint64(len(mp[k])))
+		h.Write(size[:])
+		h.Write([]byte(k))
+		h.Write([]byte(mp[k]))
+	}
+	return h.Sum(nil)
+}
+
+// digests of PREFIXES, the empty ones are left out
+func (t *merkleTree) digestsOf(prefixes []string) map[string][]byte {
+	ret := make(map[string][]byte)
+	for _, p := range prefixes {
+		if d, ok := t.digests[p]; ok {
+			ret[p] = d
+		}
+	}
+	return ret
+}
+
+// pairs held by the leaves LEAVES
+func (t *merkleTree) pairsOf(leaves []string) StoreType {
+	ret := make(StoreType)
+	for _, leaf := range leaves {
+		for k, v := range t.leaves[leaf] {
+			ret[k] = v
+		}
+	}
+	return ret
+}
@@ -0,0 +1,63 @@
+package chord
+
+import "DHT-2022/src/metrics"
+
+var (
+	lookupHops = metrics.Default.NewHistogramVec("chord_lookup_hops",
+		"Nodes a lookup went through, the node starting it included.", metrics.HopBuckets, "node")
+	lookupSeconds = metrics.Default.NewHistogramVec("chord_lookup_seconds",
+		"Time taken by lookups, by mode and outcome.", metrics.LatencyBuckets, "node", "mode", "outcome")
+	stabilizeRounds = metrics.Default.NewCounterVec("chord_stabilize_rounds_total",
+		"Stabilize rounds run, by outcome.", "node", "outcome")
+	fixFingerRounds = metrics.Default.NewCounterVec("chord_fix_finger_rounds_total",
+		"Fix finger rounds run, by outcome.", "node", "outcome")
+	antiEntropyRounds = metrics.Default.NewCounterVec("chord_anti_entropy_rounds_total",
+		"Anti-entropy exchanges with a replica, by outcome.", "node", "outcome")
+	antiEntropyRepairs = metrics.Default.NewCounterVec("chord_anti_entropy_repaired_keys_total",
+		"Keys repaired by anti-entropy, pushed to or dropped from a backup, or pulled from it.", "node", "action")
+	readFallbacks = metrics.Default.NewCounterVec("chord_read_fallbacks_total",
+		"Gets falling back to the replicas, by whether a replica had the key.", "node", "outcome")
+	merkleTrees = metrics.Default.NewCounterVec("chord_merkle_trees_total",
+		"Merkle trees asked for by anti-entropy, by store and whether a kept one was used.", "node", "store", "outcome")
+	readRepairs = metrics.Default.NewCounterVec("chord_read_repairs_total",
+		"Copies written back by gets falling back to the replicas.", "node")
+	identityFailures = metrics.Default.NewCounterVec("chord_identity_failures_total",
+		"Peers failing to prove the identity they claim.", "node")
+	rejectedWrites = metrics.Default.NewCounterVec("chord_rejected_writes_total",
+		"Writes refused for their signatures, by reason.", "node", "reason")
+	storedKeys = metrics.Default.NewGaugeVec("chord_stored_keys",
+		"Keys held by a node, by store.", "node", "store")
+)
+
+func outcome(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+func (m LookupMode) String() string {
+	if m == IterativeLookup {
+		return "iterative"
+	}
+	return "recursive"
+}
+
+// report the sizes of the stores of the node when scraped
+func (n *chordBaseNode) exportStores() {
+	storedKeys.With(n.addr, "data").Func(func() float64 {
+		n.dataLock.RLock()
+		defer n.dataLock.RUnlock()
+		return float64(n.data.Len())
+	})
+	storedKeys.With(n.addr, "backup").Func(func() float64 {
+		n.backupLock.RLock()
+		defer n.backupLock.RUnlock()
+		return float64(n.backup.Len())
+	})
+}
+
+// stop reporting the stores of a node leaving
+func (n *chordBaseNode) unexportStores() {
+	storedKeys.DeleteMatch("node", n.addr)
+}
@@ -1,9 +1,16 @@
 package chord
 
 import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/detector"
+	"DHT-2022/src/dht"
+	"DHT-2022/src/network"
+	"context"
 	"errors"
+	"math"
 	"net"
 	"net/rpc"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,26 +22,72 @@ type networkNode struct {
 	addr     Address
 	server   *rpc.Server
 	listener net.Listener
+	conns    network.ConnSet
+	pool     *network.Pool
+	detector *detector.Detector
 	onRing   bool
 	quitMsg  chan bool
+
+	logs        *log.Logger
+	transport   network.Transport
+	pingTimeOut time.Duration
+	clock       clock.Clock
+	manual      bool
 }
 
-func (n *networkNode) serverInit(ipaddr Address, service string, ptr interface{}) {
+func (n *networkNode) serverInit(ipaddr Address, service string, ptr interface{}, cfg Config, logs *log.Logger) {
 	n.addr, n.service, n.nPtr = ipaddr, service, ptr
+	n.logs = logs
 	n.quitMsg = make(chan bool)
+	n.transport = cfg.Transport
+	n.pingTimeOut = cfg.PingTimeout
+	n.clock, n.manual = cfg.Clock, cfg.Manual
+	n.pool = newPool(ipaddr, cfg)
+	n.conns.Auth = network.NewAuth(cfg.ClusterKey, ipaddr, cfg.Clock)
+	n.detector = detector.New(detector.Config{
+		Threshold:       suspectThreshold,
+		Window:          heartbeatWindow,
+		MinStdDev:       heartbeatMinStdDev,
+		AcceptablePause: heartbeatPause,
+		FirstInterval:   cfg.HeartbeatInterval,
+		FailureTTL:      failureTrustTime,
+		Clock:           cfg.Clock,
+	})
+}
+
+func newPool(local Address, cfg Config) *network.Pool {
+	return network.NewPool(network.PoolConfig{
+		DialAttempt: dialAttempt,
+		DialTimeout: cfg.DialTimeout,
+		MaxIdle:     poolMaxIdle,
+		MaxOpen:     poolMaxOpen,
+		IdleTimeout: poolIdleTimeOut,
+		Clock:       cfg.Clock,
+		Local:       local,
+		Faults:      cfg.Faults,
+		Auth:        network.NewAuth(cfg.ClusterKey, local, cfg.Clock),
+	}, cfg.Transport.Dial)
+}
+
+// drop the idle connections to the peers
+func (n *networkNode) poolReset() {
+	n.pool.Flush()
 }
 
 func (n *networkNode) launch() error {
 	n.server = rpc.NewServer()
 	err := n.server.RegisterName(n.service, n.nPtr)
+	if err == nil {
+		err = network.RegisterHealth(n.server)
+	}
 	if err != nil {
-		errLogger(n.addr, err).Error("launch failed while register")
+		n.errLogger(err).Error("launch failed while register")
 		// logrus.Errorf("[%s] launch failed while register, error message: %v", n.addr, err)
 		return err
 	}
-	n.listener, err = net.Listen("tcp", n.addr)
+	n.listener, err = n.transport.Listen(n.addr)
 	if err != nil {
-		errLogger(n.addr, err).Error("launch failed while listen")
+		n.errLogger(err).Error("launch failed while listen")
 		// logrus.Errorf("[%s] launch failed while listen, error message: %v", n.addr, err)
 		return err
 	}
@@ -51,85 +104,53 @@ func (n *networkNode) connect() error {
 		conn, err = n.listener.Accept()
 		select {
 		case <-n.quitMsg:
-			logger(n.addr).Info("server go offline")
+			n.logger().Info("server go offline")
 			// logrus.Infof("[%s] server go offline", n.addr)
 			return nil
 		default:
 			if err != nil {
-				errLogger(n.addr, err).Error("connect failed while accept")
+				n.errLogger(err).Error("connect failed while accept")
 				// logrus.Errorf("[%s] connect failed while accept, error message: %v", n.addr, err)
 				return err
 			} else {
-				logger(n.addr).Info("connect succeeded")
+				n.logger().Info("connect succeeded")
 				// logrus.Infof("[%s] connect succeeed", n.addr)
-				go n.server.ServeConn(conn)
+				go n.conns.Serve(n.server, conn)
 			}
 		}
 	}
 }
 
-func (n *networkNode) dial(address Address) (*rpc.Client, error) {
-	if address == NIL {
-		return nil, errors.New("invalid address")
-	}
-	var (
-		client *rpc.Client
-		err    error
-	)
-	for i := 1; i <= dialAttempt; i++ {
-		dialMsg := make(chan error, 1)
-		go func() {
-			client, err = rpc.Dial("tcp", address)
-			dialMsg <- err
-		}()
-		select {
-		case <-dialMsg:
-			if err != nil {
-				errLogger(n.addr, err).WithField("target", address).Info("dail failed")
-				// logrus.Infof("[%s] dial %s failed, error message %v", n.addr, address, err)
-				return nil, err
-			} else {
-				logger(n.addr).WithField("target", address).Info("dail succeeded")
-				// logrus.Infof("[%s] dial %s succeeded", n.addr, address)
-				return client, err
-			}
-		case <-time.After(dialTimeOut):
-			logger(n.addr).WithField("target", address).Tracef("dail time out in attempt%d", i)
-			// logrus.Tracef("[%s] dial %s time out in attempt%d", n.addr, address, i)
-		}
-	}
-	logger(n.addr).WithField("target", address).Info("dail time out")
-	// logrus.Infof("[%s] dial %s time out", n.addr, address)
-	return nil, errors.New("dial time out")
+func (n *networkNode) call(address Address, service string, method string, request interface{}, reply interface{}) error {
+	return n.callCtx(context.Background(), address, service, method, request, reply)
 }
 
-func (n *networkNode) call(address Address, service string, method string, request interface{}, reply interface{}) error {
-	client, err := n.dial(address)
-	if err != nil {
-		errLogger(n.addr, err).WithField("target", address).Error("rpc failed while dailing")
-		// logrus.Errorf("[%s] rpc failed while dail %s, error message: %v", n.addr, address, err)
-		return err
+// remote call over a pooled connection that gives up as soon as CTX is
+// done, the connection is dropped then so that the call is aborted
+func (n *networkNode) callCtx(ctx context.Context, address Address, service string, method string, request interface{}, reply interface{}) error {
+	if address == NIL {
+		return errors.New("invalid address")
 	}
-	var rpcLogger = logger(n.addr).WithFields(log.Fields{
+	var rpcLogger = n.logger().WithFields(log.Fields{
 		"target":  address,
 		"service": service,
 		"method":  method,
 	})
-	logger(n.addr).WithField("request", request).
+	n.logger().WithField("request", request).
 		Tracef("remote call sending request")
 	// logrus.Infof("[%s] remote call to method %s with request %v, reply %v", n.addr, method, request, reply)
-	err = client.Call(service+"."+method, request, reply)
+	err := n.pool.Call(ctx, address, service+"."+method, request, reply)
+	n.observe(address, err)
+	err = dht.FromRPC(err)
+	if errors.Is(err, dht.ErrNotFound) {
+		return err
+	}
 	if err != nil {
 		rpcLogger.WithError(err).Error("rpc failed while calling")
 		// logrus.Errorf("[%s] rpc failed while call %s at %s, error message: %v", n.addr, method, address, err)
 		return err
 	}
 	rpcLogger.WithField("reply", reply).Tracef("remote call got reply")
-	if err := client.Close(); err != nil {
-		rpcLogger.WithError(err).Error("rpc failed while closing")
-		// logrus.Errorf("[%s] rpc failed while close, error message: %v", n.addr, err)
-		return err
-	}
 	return nil
 }
 
@@ -137,46 +158,91 @@ func (n *networkNode) ping(address Address) bool {
 	if address == NIL {
 		return false
 	}
-	var (
-		client     *rpc.Client
-		err        error
-		pingLogger = logger(n.addr).WithField("target", address)
-	)
+	var pingLogger = n.logger().WithField("target", address)
 	for i := 1; i <= pingAttempt; i++ {
-		pingMsg := make(chan error, 1)
-		go func() {
-			client, err = rpc.Dial("tcp", address)
-			if err == nil {
-				pingLogger.Tracef("ping succeded in attempt%d", i)
-				// logrus.Tracef("[%s] ping %s succeeded in attempt%d", n.addr, address, i)
-				defer client.Close()
-			} else {
-				pingLogger.Tracef("ping failed in atttempt%d", i)
-				// logrus.Tracef("[%s] ping %s failed in attempt%d", n.addr, address, i)
-			}
-			pingMsg <- err
-		}()
-		select {
-		case <-pingMsg:
-			if err != nil {
-				pingLogger.Info("ping failed")
-				// logrus.Infof("[%s] ping %s failed, error message: %v", n.addr, address, err)
-				return false
-			} else {
-				pingLogger.Info("ping succeeded")
-				// logrus.Infof("[%s] ping %s succeeded", n.addr, address)
-				return true
-			}
-		case <-time.After(pingTimeOut):
-			pingLogger.Tracef("ping time out in attempt%d", i)
-			// logrus.Tracef("[%s] ping %s time out in attempt%d", n.addr, address, i)
+		ctx, cancel := n.clock.WithTimeout(context.Background(), n.pingTimeOut)
+		err := n.pool.Ping(ctx, address)
+		cancel()
+		n.observe(address, err)
+		if err == nil {
+			pingLogger.Info("ping succeeded")
+			// logrus.Infof("[%s] ping %s succeeded", n.addr, address)
+			return true
+		}
+		if !errors.Is(err, dht.ErrTimeout) {
+			pingLogger.WithError(err).Info("ping failed")
+			// logrus.Infof("[%s] ping %s failed, error message: %v", n.addr, address, err)
+			return false
 		}
+		pingLogger.Tracef("ping time out in attempt%d", i)
+		// logrus.Tracef("[%s] ping %s time out in attempt%d", n.addr, address, i)
 	}
 	pingLogger.Info("ping time out")
 	// logrus.Infof("[%s] ping %s time out", n.addr, address)
+	n.detector.Failure(address)
 	return false
 }
 
+// feed the outcome of a call to ADDRESS to the failure detector
+func (n *networkNode) observe(address Address, err error) {
+	alive, failed := network.Outcome(err)
+	if alive {
+		n.detector.Success(address)
+	} else if failed {
+		n.detector.Failure(address)
+	}
+}
+
+// whether ADDRESS is taken as online. Only the successors and the
+// predecessor are heartbeated, so a peer suspected for being silent for
+// long is pinged to make sure, and one that failed a call is taken as down
+func (n *networkNode) alive(address Address) bool {
+	if address == NIL {
+		return false
+	}
+	phi, known := n.detector.Phi(address)
+	if known && phi < suspectThreshold {
+		return true
+	}
+	if known && math.IsInf(phi, 1) {
+		return false
+	}
+	return n.ping(address)
+}
+
+// send a heartbeat to each of TARGETS, the ones not answering in time are
+// left to the rising suspicion level
+func (n *networkNode) heartbeat(targets []Address) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		if n.manual {
+			// one at a time, for the order of the calls to be reproducible
+			n.beat(target)
+			continue
+		}
+		wg.Add(1)
+		go func(target Address) {
+			defer wg.Done()
+			n.beat(target)
+		}(target)
+	}
+	wg.Wait()
+	n.detector.Prune(detectorForgetTime, func(addr Address) bool {
+		return inList(addr, targets)
+	})
+}
+
+func (n *networkNode) beat(target Address) {
+	ctx, cancel := n.clock.WithTimeout(context.Background(), n.pingTimeOut)
+	err := n.pool.Ping(ctx, target)
+	cancel()
+	if err == nil {
+		n.detector.Heartbeat(target)
+	} else if _, failed := network.Outcome(err); failed {
+		n.detector.Failure(target)
+	}
+}
+
 func (n *networkNode) shutdown(num int) {
 	n.onRing = false
 	close(n.quitMsg)
@@ -185,7 +251,9 @@ func (n *networkNode) shutdown(num int) {
 	// }
 	err := n.listener.Close()
 	if err != nil {
-		errLogger(n.addr, err).Error("shutdown failed")
+		n.errLogger(err).Error("shutdown failed")
 		// logrus.Errorf("[%s] shutdown failed, error message %v", n.addr, err)
 	}
+	// the pooled connections of the peers outlive the listener
+	n.conns.CloseAll()
 }
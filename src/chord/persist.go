@@ -0,0 +1,119 @@
+package chord
+
+import (
+	"DHT-2022/src/store"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// the first durable layout kept data and backup of a node in one log and
+// one snapshot right in its directory, while the engines keep a
+// sub-directory each. A directory left in the old layout is moved over
+// to the engines when the node opens it
+
+const (
+	legacyWalFile      = "wal.log"
+	legacySnapshotFile = "snapshot.json"
+)
+
+type legacyRecord struct {
+	Op    string
+	Store string
+	Key   KeyType
+	Val   ValueType
+}
+
+type legacySnapshot struct {
+	Data   StoreType
+	Backup StoreType
+}
+
+// move the data kept in DIR in the old layout to the engines given by
+// store.Disk(DIR), nothing is done if DIR is not in the old layout. The
+// values had no versions then, they are stored as records of version 0
+func upgradeDataDir(dir string) error {
+	walPath := filepath.Join(dir, legacyWalFile)
+	snapPath := filepath.Join(dir, legacySnapshotFile)
+	snap := legacySnapshot{Data: make(StoreType), Backup: make(StoreType)}
+	raw, err := os.ReadFile(snapPath)
+	if err == nil {
+		if err = json.Unmarshal(raw, &snap); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	found, err := replayLegacyLog(walPath, &snap)
+	if err != nil || (raw == nil && !found) {
+		return err
+	}
+	opener := store.Disk(dir)
+	for name, mp := range map[string]StoreType{"data": snap.Data, "backup": snap.Backup} {
+		e, err := opener(name)
+		if err != nil {
+			return err
+		}
+		recs := make(StoreType, len(mp))
+		for k, v := range mp {
+			recs[k] = record{Value: v}.encode()
+		}
+		err = appendEngine(e, recs)
+		if cerr := e.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// the old files go only once their content is in the engines
+	if err := os.Remove(walPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(snapPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// replay the old log at PATH on SNAP, tells whether there was one
+func replayLegacyLog(path string, snap *legacySnapshot) (bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if snap.Data == nil {
+		snap.Data = make(StoreType)
+	}
+	if snap.Backup == nil {
+		snap.Backup = make(StoreType)
+	}
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var rec legacyRecord
+		// a torn record at the tail is left by a crash during the write
+		if err := dec.Decode(&rec); err != nil {
+			return true, nil
+		}
+		mp := snap.Data
+		if rec.Store == "backup" {
+			mp = snap.Backup
+		}
+		switch rec.Op {
+		case "put":
+			mp[rec.Key] = rec.Val
+		case "del":
+			delete(mp, rec.Key)
+		case "clear":
+			for k := range mp {
+				delete(mp, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package chord
+
+import (
+	"DHT-2022/src/dht"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a key is kept in N copies, the data of its owner and the backups of the
+// replicas of the owner known alive. Writes go to all of them and succeed
+// once W copies are written, reads take the newest of R copies and write
+// it back to the copies found stale
+
+// Consistency is the number of copies of a key a read or write waits for
+type Consistency int
+
+const (
+	// the level set in the config of the node
+	DefaultLevel Consistency = 0
+	One          Consistency = 1
+	// a majority of the copies
+	Quorum Consistency = -1
+	All    Consistency = -2
+)
+
+// copies needed out of N, a count above N needs all of them
+func (c Consistency) need(n int) int {
+	switch {
+	case c == Quorum:
+		return n/2 + 1
+	case c == All || int(c) > n:
+		return n
+	}
+	return int(c)
+}
+
+func (c Consistency) String() string {
+	switch c {
+	case DefaultLevel:
+		return "default"
+	case Quorum:
+		return "quorum"
+	case All:
+		return "all"
+	}
+	return strconv.Itoa(int(c))
+}
+
+// QuorumError tells how many of the N copies of a key answered a read or
+// write that needed NEED of them, and whether the owner was among them.
+// It is the cause of the errors matching dht.ErrQuorum, or
+// dht.ErrReplicaWrite if the write got the copies it needed
+type QuorumError struct {
+	Acks  int
+	Need  int
+	N     int
+	Owner bool
+	// copies refusing the write as not authorized
+	Refused int
+}
+
+func (e *QuorumError) Error() string {
+	owner := "owner included"
+	if !e.Owner {
+		owner = "owner not included"
+	}
+	msg := fmt.Sprintf("%d of %d copies answered, %d needed, %s", e.Acks, e.N, e.Need, owner)
+	if e.Refused > 0 {
+		msg += fmt.Sprintf(", %d refused", e.Refused)
+	}
+	return msg
+}
+
+// whether the write the error is about still met its level, only some
+// copies beyond the needed ones missing it
+func (e *QuorumError) met() bool {
+	return e.Acks >= e.Need
+}
+
+// answer of a node to the read or write of a copy of a key
+type copyReply struct {
+	target Address
+	rec    record
+	found  bool
+	// false if the copy of a replica was found in its data only
+	inBackup bool
+	err      error
+}
+
+// run CALL on TARGETS and hand the replies to COLLECT as they come, until
+// it returns false. The calls are made one after another in manual mode,
+// otherwise the ones left run on in the background
+func (n *chordBaseNode) fanOut(targets []Address, call func(Address) copyReply, collect func(copyReply) bool) {
+	if n.cfg.Manual {
+		for _, target := range targets {
+			if !collect(call(target)) {
+				return
+			}
+		}
+		return
+	}
+	ch := make(chan copyReply, len(targets))
+	for _, target := range targets {
+		go func(target Address) {
+			ch <- call(target)
+		}(target)
+	}
+	for range targets {
+		if !collect(<-ch) {
+			return
+		}
+	}
+}
+
+// write REC of KEY to the data of OWNER and the backups of the others of
+// TARGETS, NEED of them are to be written. All the replies are waited for,
+// so that the error tells which copies are written even if NEED is met
+func (n *chordBaseNode) writeCopies(ctx context.Context, key KeyType, rec record, owner Address, targets []Address, need int) *QuorumError {
+	ret := &QuorumError{Need: need, N: len(targets)}
+	pair := DataPair{Key: key, Val: rec.encode()}
+	n.fanOut(targets, func(target Address) copyReply {
+		method := "PutBackup"
+		if target == owner {
+			method = "PutData"
+		}
+		err := n.callCtx(ctx, target, "ChordService", method, pair, nil)
+		if err != nil {
+			n.errLogger(err).WithFields(log.Fields{"key": key, "target": target}).
+				Warn("write of copy failed")
+		}
+		return copyReply{target: target, err: err}
+	}, func(r copyReply) bool {
+		if r.err == nil {
+			ret.Acks++
+			ret.Owner = ret.Owner || r.target == owner
+		} else if errors.Is(r.err, dht.ErrUnauthorized) {
+			ret.Refused++
+		}
+		return true
+	})
+	if ret.Acks < len(targets) {
+		return ret
+	}
+	return nil
+}
+
+// read KEY from the data of OWNER and the backups of the others of
+// TARGETS, until NEED of them answer, found or not. A replica missing the
+// key in its backup is asked for its data too, as a node joining ahead of
+// it may not have taken the key over yet. Copies whose signatures do not
+// hold are taken as failed reads. The last error is returned along
+func (n *chordBaseNode) readCopies(ctx context.Context, key KeyType, owner Address, targets []Address, need int) (copies []copyReply, err error) {
+	n.fanOut(targets, func(target Address) copyReply {
+		var (
+			v     ValueType
+			r     = copyReply{target: target}
+			inner error
+		)
+		if target != owner {
+			inner = n.callCtx(ctx, target, "ChordService", "GetBackup", key, &v)
+			r.inBackup = inner == nil
+		}
+		if target == owner || errors.Is(inner, dht.ErrNotFound) {
+			inner = n.callCtx(ctx, target, "ChordService", "GetData", key, &v)
+		}
+		if inner == nil {
+			r.rec, r.found = decodeRecord(v), true
+			if _, err := openRecord(key, r.rec); err != nil {
+				n.logger().WithFields(log.Fields{"key": key, "target": target}).WithError(err).
+					Warn("copy with invalid signature")
+				r.rec, r.found, r.err = record{}, false, dht.ErrUnauthorized
+			}
+		} else if !errors.Is(inner, dht.ErrNotFound) {
+			r.err = inner
+		}
+		return r
+	}, func(r copyReply) bool {
+		if r.err != nil {
+			err = r.err
+		} else {
+			copies = append(copies, r)
+		}
+		return len(copies) < need
+	})
+	return copies, err
+}
+
+// the newest of the copies found
+func newest(copies []copyReply) (record, bool) {
+	var (
+		ret   record
+		found bool
+	)
+	for _, c := range copies {
+		if c.found && (!found || c.rec.newer(ret)) {
+			ret, found = c.rec, true
+		}
+	}
+	return ret, found
+}
+
+// write REC back to the COPIES missing it or holding an older record, a
+// tombstone goes only to the copies still holding a value, so that a
+// delete failed halfway is finished instead of undone
+func (n *chordBaseNode) readRepair(ctx context.Context, key KeyType, owner Address, rec record, copies []copyReply) {
+	pair := DataPair{Key: key, Val: rec.encode()}
+	repairLogger := n.logger().WithFields(log.Fields{"key": key, "version": rec.Version})
+	for _, c := range copies {
+		stale := !c.found || rec.newer(c.rec) || (c.target != owner && !c.inBackup)
+		if !stale || (rec.Deleted && !c.found) {
+			continue
+		}
+		method := "PutBackup"
+		if c.target == owner {
+			method = "PutData"
+		}
+		if err := n.callCtx(ctx, c.target, "ChordService", method, pair, nil); err != nil {
+			repairLogger.WithError(err).WithField("target", c.target).Warn("read repair failed")
+			continue
+		}
+		readRepairs.With(n.addr).Inc()
+	}
+}
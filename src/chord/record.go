@@ -0,0 +1,99 @@
+package chord
+
+import (
+	"DHT-2022/src/store"
+	"fmt"
+	"strconv"
+)
+
+// record is a value with the version of the write that stored it, the
+// engines keep records encoded as strings so that all the ways data moves
+// between nodes carry the version along. Every value is stored encoded,
+// the values of the old data directory layout included, so a value is
+// never taken for the encoding of another. A delete leaves a tombstone, a
+// record without value, so that a copy missing the delete does not bring
+// the key back. Tombstones are dropped once older than tombstoneTTL
+type record struct {
+	Version int64
+	Value   ValueType
+	Deleted bool
+}
+
+// recordMark starts an encoded record and tombstoneMark an encoded
+// tombstone, followed by the version in recordDigits hex digits
+const (
+	recordMark    = '\x00'
+	tombstoneMark = '\x02'
+	recordDigits  = 16
+)
+
+func (r record) encode() ValueType {
+	if r.Deleted {
+		return fmt.Sprintf("%c%0*x", tombstoneMark, recordDigits, uint64(r.Version))
+	}
+	return fmt.Sprintf("%c%0*x%s", recordMark, recordDigits, uint64(r.Version), r.Value)
+}
+
+// decode S written by encode, a string not written by it is taken as a
+// value of version 0
+func decodeRecord(s ValueType) record {
+	if len(s) > recordDigits && (s[0] == recordMark || s[0] == tombstoneMark) {
+		if v, err := strconv.ParseUint(s[1:1+recordDigits], 16, 64); err == nil {
+			if s[0] == tombstoneMark {
+				return record{Version: int64(v), Deleted: true}
+			}
+			return record{Version: int64(v), Value: s[1+recordDigits:]}
+		}
+	}
+	return record{Value: s}
+}
+
+// newer tells whether R wins over O, ties of versions are broken in favour
+// of tombstones then by the values, so that every node picks the same one
+func (r record) newer(o record) bool {
+	if r.Version != o.Version {
+		return r.Version > o.Version
+	}
+	if r.Deleted != o.Deleted {
+		return r.Deleted
+	}
+	return r.Value > o.Value
+}
+
+// version for a write coordinated by the node, the time of its clock kept
+// increasing
+func (n *chordBaseNode) nextVersion() int64 {
+	n.versionLock.Lock()
+	defer n.versionLock.Unlock()
+	v := n.cfg.Clock.Now().UnixNano()
+	if v <= n.lastVersion {
+		v = n.lastVersion + 1
+	}
+	n.lastVersion = v
+	return v
+}
+
+// put V under K in E unless E holds a newer record of K, a tombstone
+// included
+func mergeEngine(e store.Engine, k KeyType, v ValueType) error {
+	if old, ok := e.Get(k); ok && decodeRecord(old).newer(decodeRecord(v)) {
+		return nil
+	}
+	return e.Put(k, v)
+}
+
+// drop the tombstones of E older than BEFORE, by then the delete has
+// reached every copy of the key
+func purgeTombstones(e store.Engine, before int64) int {
+	var stale []KeyType
+	e.ForEach(func(k, v string) bool {
+		if rec := decodeRecord(v); rec.Deleted && rec.Version < before {
+			stale = append(stale, k)
+		}
+		return true
+	})
+	for _, k := range stale {
+		e.Delete(k)
+	}
+	return len(stale)
+}
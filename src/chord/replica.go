@@ -0,0 +1,115 @@
+package chord
+
+import "context"
+
+// data owned by a node is copied into the backup of its first
+// ReplicaNum alive successors, so that the key survives as long as
+// one of these nodes is still online
+
+func (n *chordBaseNode) GetReplicas(_ string, reply *[]Address) error {
+	n.succLock.RLock()
+	defer n.succLock.RUnlock()
+	*reply = make([]Address, 0, n.cfg.ReplicaNum)
+	for _, succ := range n.succList {
+		if len(*reply) == n.cfg.ReplicaNum {
+			break
+		}
+		if succ == NIL || succ == n.addr || inList(succ, *reply) {
+			continue
+		}
+		if n.alive(succ) {
+			*reply = append(*reply, succ)
+		}
+	}
+	return nil
+}
+
+// push the whole data to the successors newly entering the replica set,
+// called after each stablization to restore the replica count
+func (n *chordBaseNode) FixReplica(_ string, _ *string) error {
+	var (
+		targets []Address
+		data    StoreType
+	)
+	n.GetReplicas(NIL, &targets)
+	n.replicaLock.Lock()
+	defer n.replicaLock.Unlock()
+	synced := make([]Address, 0, len(targets))
+	for _, target := range targets {
+		if inList(target, n.replicas) {
+			synced = append(synced, target)
+			continue
+		}
+		if data == nil {
+			data = make(StoreType)
+			n.CopyData(NIL, &data)
+		}
+		err := n.call(target, "ChordService", "AppendBackup", data, nil)
+		if err != nil {
+			n.errLogger(err).WithField("target", target).
+				Warn("fix replica warning")
+			continue
+		}
+		synced = append(synced, target)
+	}
+	n.replicas = synced
+	return nil
+}
+
+// copy a batch of data to every replica of the node
+func (n *chordBaseNode) spreadReplica(mp StoreType) error {
+	if len(mp) == 0 {
+		return nil
+	}
+	var (
+		targets []Address
+		ret     error
+	)
+	n.GetReplicas(NIL, &targets)
+	for _, target := range targets {
+		err := n.call(target, "ChordService", "AppendBackup", mp, nil)
+		if err != nil {
+			n.errLogger(err).WithField("target", target).
+				Warn("spread replica warning")
+			ret = err
+		}
+	}
+	return ret
+}
+
+// replicas keeping the backup of OWNER, if the owner can not be reached
+// they are taken from the successor list of its predecessor, the last node
+// asked by an iterative lookup for the owner
+func (n *chordBaseNode) replicasOf(ctx context.Context, owner Address) []Address {
+	var ret []Address
+	if err := n.callCtx(ctx, owner, "ChordService", "GetReplicas", NIL, &ret); err == nil {
+		return ret
+	}
+	trace, err := n.lookup(ctx, n.idOf(owner))
+	if err != nil {
+		return nil
+	}
+	var list []Address
+	if pred := trace.Path[len(trace.Path)-1].Addr; pred == n.addr {
+		n.GetSuccList(NIL, &list)
+	} else if err := n.callCtx(ctx, pred, "ChordService", "GetSuccList", NIL, &list); err != nil {
+		return nil
+	}
+	ret = nil
+	for _, succ := range list {
+		if len(ret) == n.cfg.ReplicaNum {
+			break
+		}
+		if succ != NIL && succ != owner && !inList(succ, ret) {
+			ret = append(ret, succ)
+		}
+	}
+	return ret
+}
+
+func hitOrMiss(found bool) string {
+	if found {
+		return "hit"
+	}
+	return "miss"
+}
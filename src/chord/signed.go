@@ -0,0 +1,114 @@
+package chord
+
+import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/identity"
+	"DHT-2022/src/store"
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a value may be signed by the node publishing it, the signature covers
+// the version of the record so that an old value can not be replayed as a
+// newer one. Every write is checked, the keys moved between the nodes by
+// joins, quits and anti-entropy included
+
+// open the value of REC stored under KEY, checking its signature
+func openRecord(key KeyType, rec record) (identity.Value, error) {
+	v, err := identity.Open(key, rec.Value)
+	if err == nil && v.Signed() && v.Seq != rec.Version {
+		err = identity.ErrBadSignature
+	}
+	return v, err
+}
+
+func (n *chordBaseNode) PutData(p DataPair, _ *string) error {
+	n.dataLock.Lock()
+	defer n.dataLock.Unlock()
+	if err := n.admit(n.data, p); err != nil {
+		return err
+	}
+	return mergeEngine(n.data, p.Key, p.Val)
+}
+
+func (n *chordBaseNode) PutBackup(p DataPair, _ *string) error {
+	n.backupLock.Lock()
+	defer n.backupLock.Unlock()
+	if err := n.admit(n.backup, p); err != nil {
+		return err
+	}
+	return mergeEngine(n.backup, p.Key, p.Val)
+}
+
+// the bulk writes drop the pairs refused and keep the others, a value
+// refused by SetData or SetBackup leaves the one stored in place
+
+func (n *chordBaseNode) SetData(mp StoreType, _ *string) error {
+	n.dataLock.Lock()
+	defer n.dataLock.Unlock()
+	return setEngine(n.data, n.admitted(n.data, mp, true))
+}
+
+func (n *chordBaseNode) SetBackup(mp StoreType, _ *string) error {
+	n.backupLock.Lock()
+	defer n.backupLock.Unlock()
+	return setEngine(n.backup, n.admitted(n.backup, mp, true))
+}
+
+func (n *chordBaseNode) AppendData(mp StoreType, _ *string) error {
+	n.dataLock.Lock()
+	defer n.dataLock.Unlock()
+	return appendEngine(n.data, n.admitted(n.data, mp, false))
+}
+
+func (n *chordBaseNode) AppendBackup(mp StoreType, _ *string) error {
+	n.backupLock.Lock()
+	defer n.backupLock.Unlock()
+	return appendEngine(n.backup, n.admitted(n.backup, mp, false))
+}
+
+// the pairs of MP admitted into E, a pair refused is replaced by the
+// value stored in E if KEEP is set
+func (n *chordBaseNode) admitted(e store.Engine, mp StoreType, keep bool) StoreType {
+	ret := make(StoreType, len(mp))
+	for k, v := range mp {
+		if n.admit(e, DataPair{k, v}) == nil {
+			ret[k] = v
+		} else if old, ok := e.Get(k); ok && keep {
+			ret[k] = old
+		}
+	}
+	return ret
+}
+
+// refuse P if its signature does not hold, if it replaces a value signed
+// by another publisher in E, or if it is not signed while the node
+// requires so. Tombstones are let through as deletes always were
+func (n *chordBaseNode) admit(e store.Engine, p DataPair) error {
+	rec := decodeRecord(p.Val)
+	if rec.Deleted {
+		return nil
+	}
+	old, _ := e.Get(p.Key)
+	v, err := identity.Check(p.Key, decodeRecord(old).Value, rec.Value, n.cfg.RequireSigned)
+	if err == nil && v.Signed() && v.Seq != rec.Version {
+		err = identity.ErrBadSignature
+	}
+	if err != nil {
+		n.logger().WithFields(log.Fields{"key": p.Key, "version": rec.Version}).WithError(err).
+			Warn("write refused")
+		rejectedWrites.With(n.addr, identity.Reason(err)).Inc()
+		return dht.ErrUnauthorized
+	}
+	return nil
+}
+
+// write VALUE signed by the node under KEY
+func (n *chordBaseNode) putSigned(ctx context.Context, key KeyType, value ValueType, level Consistency) error {
+	if n.cfg.Identity == nil {
+		return dht.NewError("put", key, dht.ErrUnauthorized, identity.ErrNoIdentity)
+	}
+	version := n.nextVersion()
+	return n.putRecord(ctx, key, record{Version: version, Value: n.cfg.Identity.SignValue(key, value, version)}, level)
+}
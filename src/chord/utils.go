@@ -1,31 +1,61 @@
 package chord
 
 import (
-	"crypto/sha1"
-	"math/big"
+	"DHT-2022/src/ident"
 	"time"
 )
 
 const (
-	NIL                = ""
-	M                  = 160
-	succListLen        = 5
-	pingAttempt        = 4
-	dialAttempt        = 3
-	pingTimeOut        = 300 * time.Millisecond
-	dialTimeOut        = 300 * time.Millisecond
-	stablizePauseTime  = 100 * time.Millisecond
-	fixfingerPauseTime = 100 * time.Millisecond
-	maintainerNum      = 3
-)
+	NIL = ""
+	// widest identifiers, digests are cut to it
+	M = ident.Bits
+
+	defaultSuccListLen         = 5
+	defaultReplicaNum          = 1
+	defaultMaxHops             = 32
+	defaultPingTimeOut         = 300 * time.Millisecond
+	defaultDialTimeOut         = 300 * time.Millisecond
+	defaultStabilizeInterval   = 100 * time.Millisecond
+	defaultFixFingerInterval   = 100 * time.Millisecond
+	defaultHeartbeatInterval   = 250 * time.Millisecond
+	defaultAntiEntropyInterval = time.Second
+
+	pingAttempt     = 4
+	dialAttempt     = 3
+	poolMaxIdle     = 2
+	poolMaxOpen     = 128
+	poolIdleTimeOut = 30 * time.Second
+	maintainerNum   = 4
+
+	// hex digits of the sha1 of a key naming its leaf in a Merkle tree
+	merkleDepth = 3
+
+	// nodes a ring check walks at most
+	ringCheckMaxNodes = 1 << 16
 
-var (
-	RingSize = pow2(M)
+	// identities proved by the peers are checked again after this long
+	identityTTL = time.Minute
+
+	// nodes found down are probed for this long after, to merge the rings
+	// a partition has split, and this many of them at most
+	lostForgetTime = 10 * time.Minute
+	lostMaxNodes   = 64
+
+	// tombstones are kept this long, a copy offline for longer may bring
+	// a deleted key back
+	tombstoneTTL = 10 * time.Minute
+
+	heartbeatWindow    = 100
+	heartbeatMinStdDev = 100 * time.Millisecond
+	heartbeatPause     = 500 * time.Millisecond
+	suspectThreshold   = 8.0
+	detectorForgetTime = 5 * time.Second
+	failureTrustTime   = time.Second
 )
 
 type (
 	Address   = string
-	Identifer = *big.Int
+	Identifer = ident.ID
 	KeyType   = string
 	ValueType = string
 )
@@ -35,20 +65,6 @@ type DataPair struct {
 	Val ValueType
 }
 
-func hash(key string) Identifer {
-	hasher := sha1.New()
-	hasher.Write([]byte(key))
-	return new(big.Int).SetBytes(hasher.Sum(nil))
-}
-
-func pow2(x int) Identifer {
-	return new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(x)), nil)
-}
-
-func getStart(addr string, x int) Identifer {
-	return new(big.Int).Mod(new(big.Int).Add(hash(addr), pow2(x)), RingSize)
-}
-
 func contain(id, lower, upper Identifer, bound string) bool {
 	if lower.Cmp(upper) < 0 {
 		switch bound {
@@ -76,6 +92,15 @@ func contain(id, lower, upper Identifer, bound string) bool {
 	return false
 }
 
+func inList(addr Address, list []Address) bool {
+	for _, v := range list {
+		if v == addr {
+			return true
+		}
+	}
+	return false
+}
+
 // func simplify(args ...string) []string {
 // 	ret := make([]string, 0, len(args))
 // 	for _, s := range args {
@@ -0,0 +1,236 @@
+package clock
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Virtual is a clock that only moves when told to, the timers due are
+// fired in the order of their deadlines, and of their creation for equal
+// deadlines, so that a run driven by it is reproducible
+type Virtual struct {
+	lock   sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers timerHeap
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+type vTimer struct {
+	clock  *Virtual
+	when   time.Time
+	seq    uint64
+	period time.Duration
+	fire   func(now time.Time)
+	index  int
+}
+
+func (v *Virtual) Now() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+// Sleep blocks until another goroutine advances the clock by D
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	v.schedule(d, 0, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (v *Virtual) AfterFunc(d time.Duration, f func()) Timer {
+	return v.schedule(d, 0, func(time.Time) { f() })
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	ch := make(chan time.Time, 1)
+	t := v.schedule(d, d, func(now time.Time) {
+		// drop the tick if the last one is not taken, as time.Ticker does
+		select {
+		case ch <- now:
+		default:
+		}
+	})
+	return &vTicker{t, ch}
+}
+
+func (v *Virtual) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancel(parent)
+	ctx := &timeoutCtx{Context: inner, deadline: v.Now().Add(d)}
+	t := v.AfterFunc(d, func() {
+		ctx.lock.Lock()
+		ctx.err = context.DeadlineExceeded
+		ctx.lock.Unlock()
+		cancel()
+	})
+	return ctx, func() {
+		t.Stop()
+		cancel()
+	}
+}
+
+// Advance moves the clock forward by D, firing the timers due on the way
+func (v *Virtual) Advance(d time.Duration) {
+	v.AdvanceTo(v.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward to T, firing the timers due on the way
+func (v *Virtual) AdvanceTo(t time.Time) {
+	for v.fireNext(t) {
+	}
+	v.lock.Lock()
+	if t.After(v.now) {
+		v.now = t
+	}
+	v.lock.Unlock()
+}
+
+// Step moves the clock to the next deadline and fires the timers due then,
+// false if there is no timer left
+func (v *Virtual) Step() bool {
+	v.lock.Lock()
+	if len(v.timers) == 0 {
+		v.lock.Unlock()
+		return false
+	}
+	next := v.timers[0].when
+	v.lock.Unlock()
+	v.AdvanceTo(next)
+	return true
+}
+
+// Next returns the deadline of the next timer, false if there is none
+func (v *Virtual) Next() (time.Time, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.timers) == 0 {
+		return time.Time{}, false
+	}
+	return v.timers[0].when, true
+}
+
+// fire the earliest timer due by T, it runs without the lock so that it
+// may set new timers
+func (v *Virtual) fireNext(t time.Time) bool {
+	v.lock.Lock()
+	if len(v.timers) == 0 || v.timers[0].when.After(t) {
+		v.lock.Unlock()
+		return false
+	}
+	timer := v.timers[0]
+	v.now = timer.when
+	if timer.period > 0 {
+		timer.when = timer.when.Add(timer.period)
+		v.seq++
+		timer.seq = v.seq
+		heap.Fix(&v.timers, 0)
+	} else {
+		heap.Pop(&v.timers)
+	}
+	now := v.now
+	v.lock.Unlock()
+	timer.fire(now)
+	return true
+}
+
+func (v *Virtual) schedule(d, period time.Duration, fire func(time.Time)) *vTimer {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.seq++
+	t := &vTimer{clock: v, when: v.now.Add(d), seq: v.seq, period: period, fire: fire}
+	heap.Push(&v.timers, t)
+	return t
+}
+
+func (t *vTimer) Stop() bool {
+	v := t.clock
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&v.timers, t.index)
+	return true
+}
+
+type vTicker struct {
+	timer *vTimer
+	ch    chan time.Time
+}
+
+func (t *vTicker) Chan() <-chan time.Time {
+	return t.ch
+}
+
+func (t *vTicker) Stop() {
+	t.timer.Stop()
+}
+
+// a context expiring by a virtual clock, it reports DeadlineExceeded as
+// one made by context.WithTimeout does
+type timeoutCtx struct {
+	context.Context
+	deadline time.Time
+	lock     sync.Mutex
+	err      error
+}
+
+func (c *timeoutCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutCtx) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
+
+type timerHeap []*vTimer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*vTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
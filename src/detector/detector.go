@@ -0,0 +1,194 @@
+package detector
+
+import (
+	"DHT-2022/src/clock"
+	"math"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// phi over which a peer is suspected
+	Threshold float64
+	// heartbeat intervals kept for each peer
+	Window int
+	// lower bound of the deviation, so that steady heartbeats do not make
+	// the detector over sensitive
+	MinStdDev time.Duration
+	// silence tolerated on top of the usual heartbeat interval
+	AcceptablePause time.Duration
+	// heartbeat interval assumed before any is measured
+	FirstInterval time.Duration
+	// a failed call is trusted for this long, then nothing is taken as
+	// known about the peer until it is heard from or fails again
+	FailureTTL time.Duration
+	// the real clock by default
+	Clock clock.Clock
+}
+
+type peer struct {
+	intervals []time.Duration
+	next      int
+	lastBeat  time.Time
+	lastSeen  time.Time
+	// whether the peer has answered once, LASTSEEN is the time it was
+	// first known at otherwise
+	heard    bool
+	failed   bool
+	failedAt time.Time
+}
+
+// Detector is a phi-accrual failure detector, it keeps a suspicion level
+// for each peer from the arrival of heartbeats, and from the outcome of
+// the calls made to it. A peer refusing a call is suspected at once until
+// it is heard from again, or the failure is no longer trusted
+type Detector struct {
+	cfg   Config
+	lock  sync.Mutex
+	peers map[string]*peer
+}
+
+func New(cfg Config) *Detector {
+	if cfg.Window < 1 {
+		cfg.Window = 1
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	return &Detector{cfg: cfg, peers: make(map[string]*peer)}
+}
+
+func (d *Detector) get(addr string, now time.Time) *peer {
+	p, ok := d.peers[addr]
+	if !ok {
+		p = &peer{intervals: make([]time.Duration, 0, d.cfg.Window), lastSeen: now}
+		d.peers[addr] = p
+	}
+	return p
+}
+
+// Heartbeat records a heartbeat answered by ADDR
+func (d *Detector) Heartbeat(addr string) {
+	now := d.cfg.Clock.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p := d.get(addr, now)
+	if !p.lastBeat.IsZero() {
+		interval := now.Sub(p.lastBeat)
+		if len(p.intervals) < d.cfg.Window {
+			p.intervals = append(p.intervals, interval)
+		} else {
+			p.intervals[p.next] = interval
+			p.next = (p.next + 1) % d.cfg.Window
+		}
+	}
+	p.lastBeat, p.lastSeen, p.heard, p.failed = now, now, true, false
+}
+
+// Success records a call answered by ADDR, it tells the peer is alive but
+// is not taken as a heartbeat interval
+func (d *Detector) Success(addr string) {
+	now := d.cfg.Clock.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p := d.get(addr, now)
+	p.lastSeen, p.heard, p.failed = now, true, false
+}
+
+// Failure records a call to ADDR that failed at connection level
+func (d *Detector) Failure(addr string) {
+	now := d.cfg.Clock.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p := d.get(addr, now)
+	p.failed, p.failedAt = true, now
+}
+
+// Phi returns the suspicion level of ADDR, and false if nothing is known
+// about it
+func (d *Detector) Phi(addr string) (float64, bool) {
+	now := d.cfg.Clock.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p, ok := d.peers[addr]
+	if !ok {
+		return 0, false
+	}
+	if p.failed {
+		if d.cfg.FailureTTL > 0 && now.Sub(p.failedAt) >= d.cfg.FailureTTL {
+			return 0, false
+		}
+		return math.Inf(1), true
+	}
+	return d.phi(p, now), true
+}
+
+// LastSeen returns the last time ADDR answered a heartbeat or a call, and
+// false if it has not answered since it is known
+func (d *Detector) LastSeen(addr string) (time.Time, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p, ok := d.peers[addr]
+	if !ok || !p.heard {
+		return time.Time{}, false
+	}
+	return p.lastSeen, true
+}
+
+// Suspected reports whether ADDR is suspected, and false for KNOWN if
+// nothing is known about it
+func (d *Detector) Suspected(addr string) (suspected bool, known bool) {
+	phi, ok := d.Phi(addr)
+	return ok && phi >= d.cfg.Threshold, ok
+}
+
+// Forget drops what is known about ADDR
+func (d *Detector) Forget(addr string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.peers, addr)
+}
+
+// Prune drops the peers not heard from for AGE and not kept by KEEP
+func (d *Detector) Prune(age time.Duration, keep func(addr string) bool) {
+	now := d.cfg.Clock.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for addr, p := range d.peers {
+		if now.Sub(p.lastSeen) >= age && (keep == nil || !keep(addr)) {
+			delete(d.peers, addr)
+		}
+	}
+}
+
+// Reset drops everything
+func (d *Detector) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.peers = make(map[string]*peer)
+}
+
+func (d *Detector) phi(p *peer, now time.Time) float64 {
+	mean, std := float64(d.cfg.FirstInterval), float64(d.cfg.FirstInterval)/4
+	if cnt := len(p.intervals); cnt > 0 {
+		sum, sq := 0.0, 0.0
+		for _, v := range p.intervals {
+			sum += float64(v)
+			sq += float64(v) * float64(v)
+		}
+		mean = sum / float64(cnt)
+		std = math.Sqrt(math.Max(sq/float64(cnt)-mean*mean, 0))
+	}
+	std = math.Max(std, float64(d.cfg.MinStdDev))
+	if std == 0 {
+		std = 1
+	}
+	mean += float64(d.cfg.AcceptablePause)
+	// logistic approximation of the cumulative normal distribution
+	y := (float64(now.Sub(p.lastSeen)) - mean) / std
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if y > 0 {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
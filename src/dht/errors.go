@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+)
+
+var (
+	ErrNotFound     = errors.New("key not found")
+	ErrNoRoute      = errors.New("no route to the key")
+	ErrReplicaWrite = errors.New("replica write failed")
+	ErrTimeout      = errors.New("operation timed out")
+	ErrQuorum       = errors.New("quorum not reached")
+	ErrUnauthorized = errors.New("write not authorized")
+	ErrNotSupported = errors.New("operation not supported")
+)
+
+var sentinels = []error{ErrNotFound, ErrNoRoute, ErrReplicaWrite, ErrTimeout, ErrQuorum, ErrUnauthorized, ErrNotSupported}
+
+// Error describes a failed Put, Get or Delete, it matches its KIND with
+// errors.Is, and the underlying cause with errors.Unwrap
+type Error struct {
+	Op   string
+	Key  string
+	Kind error
+	Err  error
+}
+
+func NewError(op string, key string, kind error, err error) *Error {
+	return &Error{Op: op, Key: key, Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Op + " " + e.Key + ": " + e.Kind.Error()
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// FromRPC restores the errors above returned by a remote handler, which
+// net/rpc only carries as plain strings
+func FromRPC(err error) error {
+	if msg, ok := err.(rpc.ServerError); ok {
+		for _, v := range sentinels {
+			if string(msg) == v.Error() {
+				return v
+			}
+		}
+	}
+	return err
+}
+
+// deadlineError is returned in place of context.DeadlineExceeded, it
+// matches both ErrTimeout and context.DeadlineExceeded
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "operation timed out: context deadline exceeded" }
+func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Temporary() bool { return true }
+
+func (deadlineError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// FromContext converts the error of a finished context, so that a missed
+// deadline is reported as ErrTimeout
+func FromContext(err error) error {
+	if err == context.DeadlineExceeded {
+		return deadlineError{}
+	}
+	return err
+}
+
+// Wrap builds the error of a failed operation like NewError, but a missed
+// deadline of CTX takes priority over KIND
+func Wrap(ctx context.Context, op string, key string, kind error, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return NewError(op, key, ErrTimeout, FromContext(ctx.Err()))
+	}
+	return NewError(op, key, kind, err)
+}
@@ -0,0 +1,20 @@
+package dht
+
+import "context"
+
+// Node is the error-returning counterpart of the boolean Put, Get and
+// Delete, implemented by the nodes of both protocols. Errors returned
+// match one of ErrNotFound, ErrNoRoute, ErrReplicaWrite, ErrQuorum,
+// ErrUnauthorized, ErrNotSupported and ErrTimeout with errors.Is.
+//
+// The Ctx variants give up as soon as CTX is done, aborting the remote
+// calls in flight, a missed deadline is reported as ErrTimeout
+type Node interface {
+	TryPut(key string, value string) error
+	TryGet(key string) (string, error)
+	TryDelete(key string) error
+
+	PutCtx(ctx context.Context, key string, value string) error
+	GetCtx(ctx context.Context, key string) (string, error)
+	DeleteCtx(ctx context.Context, key string) error
+}
@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"DHT-2022/src/chord"
+	"DHT-2022/src/dht"
+	"DHT-2022/src/kademlia"
+	"DHT-2022/src/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultMaxValueSize = 1 << 20
+)
+
+// Node is a running DHT node served by a Gateway, both ChordNode and
+// KademliaNode are
+type Node interface {
+	dht.Node
+	Addr() string
+	Online() bool
+}
+
+var (
+	_ Node = (*chord.ChordNode)(nil)
+	_ Node = (*kademlia.KademliaNode)(nil)
+)
+
+type Config struct {
+	// time given to each request, 10s by default
+	Timeout time.Duration
+	// largest value accepted by PUT in bytes, 1MiB by default
+	MaxValueSize int64
+	// where and how much the gateway logs, warnings to stderr by default
+	Log logging.Config
+}
+
+// Gateway exposes the Put, Get and Delete of a node over HTTP, for
+// clients not speaking net/rpc:
+//
+//	PUT    /kv/{key}  stores the body, raw or as {"value": ...} in JSON
+//	GET    /kv/{key}  replies {"key": ..., "value": ...}
+//	DELETE /kv/{key}
+//	GET    /status    replies the address, liveness and counters of the node
+//
+// Keys are taken from the rest of the path once unescaped, so they may
+// hold escaped slashes. Failures reply {"error": ...} with a status
+// telling the kind of error apart
+type Gateway struct {
+	node    Node
+	cfg     Config
+	logs    *log.Logger
+	started time.Time
+
+	puts, gets, dels, errs int64
+
+	lock   sync.Mutex
+	server *http.Server
+}
+
+func New(node Node, cfg Config) (*Gateway, error) {
+	logs, err := logging.New(cfg.Log)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxValueSize <= 0 {
+		cfg.MaxValueSize = defaultMaxValueSize
+	}
+	return &Gateway{node: node, cfg: cfg, logs: logs, started: time.Now()}, nil
+}
+
+type kvReply struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type putRequest struct {
+	Value *string `json:"value"`
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+type Status struct {
+	Addr     string `json:"addr"`
+	Online   bool   `json:"online"`
+	Uptime   string `json:"uptime"`
+	Puts     int64  `json:"puts"`
+	Gets     int64  `json:"gets"`
+	Deletes  int64  `json:"deletes"`
+	Failures int64  `json:"failures"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/status":
+		if r.Method != http.MethodGet {
+			g.fail(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		g.reply(w, http.StatusOK, g.Status())
+	case strings.HasPrefix(r.URL.Path, "/kv/"):
+		g.serveKV(w, r)
+	default:
+		g.fail(w, r, http.StatusNotFound, errors.New("no such endpoint"))
+	}
+}
+
+func (g *Gateway) serveKV(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/kv/"))
+	if err != nil || key == "" {
+		g.fail(w, r, http.StatusBadRequest, errors.New("invalid key"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), g.cfg.Timeout)
+	defer cancel()
+	switch r.Method {
+	case http.MethodPut:
+		atomic.AddInt64(&g.puts, 1)
+		val, status, err := g.readValue(r)
+		if err != nil {
+			g.fail(w, r, status, err)
+			return
+		}
+		if err := g.node.PutCtx(ctx, key, val); err != nil {
+			g.fail(w, r, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		atomic.AddInt64(&g.gets, 1)
+		val, err := g.node.GetCtx(ctx, key)
+		if err != nil {
+			g.fail(w, r, statusOf(err), err)
+			return
+		}
+		g.reply(w, http.StatusOK, kvReply{Key: key, Value: val})
+	case http.MethodDelete:
+		atomic.AddInt64(&g.dels, 1)
+		if err := g.node.DeleteCtx(ctx, key); err != nil {
+			g.fail(w, r, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		g.fail(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// the value carried by a PUT, with the status to reply if it is invalid
+func (g *Gateway) readValue(r *http.Request) (string, int, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, g.cfg.MaxValueSize+1))
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if int64(len(body)) > g.cfg.MaxValueSize {
+		return "", http.StatusRequestEntityTooLarge, errors.New("value too large")
+	}
+	val := string(body)
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var req putRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Value == nil {
+			return "", http.StatusBadRequest, errors.New(`invalid body, expecting {"value": ...}`)
+		}
+		val = *req.Value
+	}
+	// the nodes take an empty value for a missing one
+	if val == "" {
+		return "", http.StatusBadRequest, errors.New("empty value")
+	}
+	return val, 0, nil
+}
+
+// the HTTP status of a failed operation
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, dht.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dht.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, dht.ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, dht.ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, context.Canceled):
+		// the client went away, nobody reads the reply
+		return 499
+	case errors.Is(err, dht.ErrNoRoute), errors.Is(err, dht.ErrReplicaWrite),
+		errors.Is(err, dht.ErrQuorum):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func (g *Gateway) fail(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if status != http.StatusNotFound {
+		atomic.AddInt64(&g.errs, 1)
+	}
+	entry := g.logs.WithField("addr", g.node.Addr()).WithField("method", r.Method).
+		WithField("path", r.URL.Path).WithField("status", status).WithError(err)
+	if status >= http.StatusInternalServerError {
+		entry.Warn("gateway request failed")
+	} else {
+		entry.Info("gateway request rejected")
+	}
+	g.reply(w, status, errorReply{Error: err.Error()})
+}
+
+func (g *Gateway) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (g *Gateway) Status() Status {
+	return Status{
+		Addr:     g.node.Addr(),
+		Online:   g.node.Online(),
+		Uptime:   time.Since(g.started).Round(time.Second).String(),
+		Puts:     atomic.LoadInt64(&g.puts),
+		Gets:     atomic.LoadInt64(&g.gets),
+		Deletes:  atomic.LoadInt64(&g.dels),
+		Failures: atomic.LoadInt64(&g.errs),
+	}
+}
+
+// ListenAndServe serves the gateway at ADDR until Shutdown is called
+func (g *Gateway) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+// Serve serves the gateway on L until Shutdown is called
+func (g *Gateway) Serve(l net.Listener) error {
+	g.lock.Lock()
+	if g.server == nil {
+		g.server = &http.Server{Handler: g, ReadHeaderTimeout: g.cfg.Timeout}
+	}
+	server := g.server
+	g.lock.Unlock()
+	err := server.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops serving, waiting for the requests in flight until CTX is
+// done
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	g.lock.Lock()
+	server := g.server
+	g.lock.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,86 @@
+// Package ident holds what the nodes of both protocols derive their
+// identifiers from
+package ident
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"fmt"
+)
+
+// Bits is the widest identifier, longer digests are cut to their first
+// Bits bits
+const Bits = 160
+
+// Hash names the function keys and addresses are hashed with, the nodes of
+// a network must agree on it
+type Hash int
+
+const (
+	SHA1 Hash = iota
+	SHA256
+	SHA3_256
+)
+
+var hashNames = map[Hash]string{
+	SHA1:     "sha1",
+	SHA256:   "sha256",
+	SHA3_256: "sha3-256",
+}
+
+func (h Hash) String() string {
+	if name, ok := hashNames[h]; ok {
+		return name
+	}
+	return fmt.Sprintf("Hash(%d)", int(h))
+}
+
+// ParseHash gives the hash of NAME as printed by String
+func ParseHash(name string) (Hash, error) {
+	for h, v := range hashNames {
+		if v == name {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash %q", name)
+}
+
+// Set parses NAME into the hash, so that a Hash can be given as a flag
+func (h *Hash) Set(name string) error {
+	ret, err := ParseHash(name)
+	if err == nil {
+		*h = ret
+	}
+	return err
+}
+
+func (h Hash) Validate() error {
+	if _, ok := hashNames[h]; !ok {
+		return fmt.Errorf("invalid config: unknown hash %d", int(h))
+	}
+	return nil
+}
+
+// Sum is the digest of DATA cut to Bits bits
+func (h Hash) Sum(data []byte) ID {
+	var ret ID
+	switch h {
+	case SHA256:
+		s := sha256.Sum256(data)
+		copy(ret[:], s[:])
+	case SHA3_256:
+		s := sha3.Sum256(data)
+		copy(ret[:], s[:])
+	default:
+		s := sha1.Sum(data)
+		copy(ret[:], s[:])
+	}
+	return ret
+}
+
+// ID is the identifier of DATA on a space of 2^M positions, the last M
+// bits of its digest
+func (h Hash) ID(data []byte, m int) ID {
+	return h.Sum(data).Trunc(m)
+}
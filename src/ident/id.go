@@ -0,0 +1,150 @@
+package ident
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"math/bits"
+	"strings"
+)
+
+// Bytes is the size of an ID
+const Bytes = Bits / 8
+
+// ID is an identifier of Bits bits, big-endian. It is a value, none of its
+// operations allocate, and an identifier narrower than Bits is kept in its
+// last bits
+type ID [Bytes]byte
+
+// Pow2 is 2^K, zero if K is Bits or more
+func Pow2(k int) ID {
+	var ret ID
+	return ret.SetBit(k)
+}
+
+func (x ID) Cmp(y ID) int {
+	return bytes.Compare(x[:], y[:])
+}
+
+func (x ID) IsZero() bool {
+	return x == ID{}
+}
+
+func (x ID) Xor(y ID) ID {
+	for i := range x {
+		x[i] ^= y[i]
+	}
+	return x
+}
+
+// Add is X + Y modulo 2^Bits
+func (x ID) Add(y ID) ID {
+	carry := uint(0)
+	for i := Bytes - 1; i >= 0; i-- {
+		sum := uint(x[i]) + uint(y[i]) + carry
+		x[i], carry = byte(sum), sum>>8
+	}
+	return x
+}
+
+// AddPow2 is X + 2^K modulo 2^Bits
+func (x ID) AddPow2(k int) ID {
+	if k < 0 || k >= Bits {
+		return x
+	}
+	i := Bytes - 1 - k/8
+	sum := uint(x[i]) + 1<<(k%8)
+	x[i] = byte(sum)
+	for sum>>8 != 0 && i > 0 {
+		i--
+		sum = uint(x[i]) + 1
+		x[i] = byte(sum)
+	}
+	return x
+}
+
+// Trunc is X modulo 2^M, its last M bits
+func (x ID) Trunc(m int) ID {
+	if m >= Bits {
+		return x
+	}
+	if m <= 0 {
+		return ID{}
+	}
+	// byte holding the M-th bit, the first one cleared
+	j := Bytes - 1 - m/8
+	for i := 0; i < j; i++ {
+		x[i] = 0
+	}
+	x[j] &= 1<<(m%8) - 1
+	return x
+}
+
+// Bit is the K-th bit of X, counted from the last one
+func (x ID) Bit(k int) uint {
+	return uint(x[Bytes-1-k/8]>>(k%8)) & 1
+}
+
+// SetBit is X with its K-th bit set, X if K is Bits or more
+func (x ID) SetBit(k int) ID {
+	if k >= 0 && k < Bits {
+		x[Bytes-1-k/8] |= 1 << (k % 8)
+	}
+	return x
+}
+
+// BitLen is the length of X in bits, 0 for zero
+func (x ID) BitLen() int {
+	for i, v := range x {
+		if v != 0 {
+			return Bits - 8*i - bits.LeadingZeros8(v)
+		}
+	}
+	return 0
+}
+
+// PrefixLen is the number of leading bits X and Y share, out of Bits
+func (x ID) PrefixLen(y ID) int {
+	return Bits - x.Xor(y).BitLen()
+}
+
+func (x ID) Big() *big.Int {
+	return new(big.Int).SetBytes(x[:])
+}
+
+// FromBig is the last Bits bits of the non-negative B
+func FromBig(b *big.Int) ID {
+	var ret ID
+	raw := b.Bytes()
+	if len(raw) > Bytes {
+		raw = raw[len(raw)-Bytes:]
+	}
+	copy(ret[Bytes-len(raw):], raw)
+	return ret
+}
+
+// String prints X in hex digits without leading zeros, as big.Int does
+func (x ID) String() string {
+	s := strings.TrimLeft(hex.EncodeToString(x[:]), "0")
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+// ParseID reads the hex digits printed by String
+func ParseID(s string) (ID, bool) {
+	var ret ID
+	if s == "" || len(s) > 2*Bytes {
+		return ret, false
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return ret, false
+	}
+	copy(ret[Bytes-len(raw):], raw)
+	return ret, true
+}
@@ -0,0 +1,100 @@
+// Package identity gives the nodes Ed25519 keypairs, so that the ID of a
+// node is derived from its public key instead of its address, and values
+// can be signed by the nodes publishing them
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Identity is the keypair of a node
+type Identity struct {
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+func Generate() (*Identity, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{public: public, private: private}, nil
+}
+
+// Load reads the private key saved to FILE by Save
+func Load(file string) (*Identity, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no private key found in %s", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", file)
+	}
+	return &Identity{public: private.Public().(ed25519.PublicKey), private: private}, nil
+}
+
+// LoadOrGenerate loads the identity saved to FILE, or makes one and saves
+// it there if the file does not exist, so that a node keeps its ID across
+// restarts
+func LoadOrGenerate(file string) (*Identity, error) {
+	ret, err := Load(file)
+	if !errors.Is(err, os.ErrNotExist) {
+		return ret, err
+	}
+	if ret, err = Generate(); err != nil {
+		return nil, err
+	}
+	return ret, ret.Save(file)
+}
+
+func (i *Identity) Save(file string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(i.private)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func (i *Identity) Public() ed25519.PublicKey {
+	return i.public
+}
+
+// Proof binds a public key to the address of a node, the node signs its
+// address with the key. It does not expire, an address moving to another
+// key is taken over by the new proof once the old one is forgotten
+type Proof struct {
+	Key ed25519.PublicKey
+	Sig []byte
+}
+
+// proofs and values are signed in distinct domains, so that a signature
+// can not be passed for the other
+const (
+	proofDomain = "DHT-2022 node address\x00"
+	valueDomain = "DHT-2022 signed value\x00"
+)
+
+func (i *Identity) Prove(addr string) Proof {
+	return Proof{Key: i.public, Sig: ed25519.Sign(i.private, []byte(proofDomain+addr))}
+}
+
+// Verify tells whether the proof was made by the node at ADDR
+func (p Proof) Verify(addr string) bool {
+	return len(p.Key) == ed25519.PublicKeySize &&
+		ed25519.Verify(p.Key, []byte(proofDomain+addr), p.Sig)
+}
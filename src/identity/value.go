@@ -0,0 +1,122 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrNoIdentity   = errors.New("node has no identity")
+	ErrBadSignature = errors.New("invalid value signature")
+	ErrUnsigned     = errors.New("value not signed")
+	ErrPublisher    = errors.New("value signed by another publisher")
+)
+
+// Value is a value as the nodes store it, signed by its publisher or not
+type Value struct {
+	Data string
+	// nil if the value is not signed
+	Publisher ed25519.PublicKey
+	// set by the publisher to order its writes to the same key
+	Seq int64
+	Sig []byte
+}
+
+func (v Value) Signed() bool {
+	return v.Publisher != nil
+}
+
+// signedMark starts a signed value, followed by the public key, the
+// sequence number and the signature in hex digits, then the data. All of
+// it is valid UTF-8 as required by the file engine
+const (
+	signedMark = '\x01'
+	keyDigits  = 2 * ed25519.PublicKeySize
+	seqDigits  = 16
+	sigDigits  = 2 * ed25519.SignatureSize
+	headerLen  = 1 + keyDigits + seqDigits + sigDigits
+)
+
+// the signature covers the key too, a value signed for one key can not be
+// stored under another
+func valueMessage(key string, seq int64, data string) []byte {
+	var b bytes.Buffer
+	var num [8]byte
+	b.WriteString(valueDomain)
+	binary.BigEndian.PutUint64(num[:], uint64(len(key)))
+	b.Write(num[:])
+	b.WriteString(key)
+	binary.BigEndian.PutUint64(num[:], uint64(seq))
+	b.Write(num[:])
+	b.WriteString(data)
+	return b.Bytes()
+}
+
+// SignValue signs DATA to be stored under KEY, the result is stored in
+// place of DATA
+func (i *Identity) SignValue(key, data string, seq int64) string {
+	sig := ed25519.Sign(i.private, valueMessage(key, seq, data))
+	return fmt.Sprintf("%c%x%0*x%x%s", signedMark, []byte(i.public), seqDigits, uint64(seq), sig, data)
+}
+
+// Open decodes the value STORED under KEY and checks its signature if it
+// is signed. Values not starting as signed ones are taken as plain data
+func Open(key, stored string) (Value, error) {
+	ret := Parse(stored)
+	if ret.Signed() && !ed25519.Verify(ret.Publisher, valueMessage(key, ret.Seq, ret.Data), ret.Sig) {
+		return ret, ErrBadSignature
+	}
+	return ret, nil
+}
+
+// Parse decodes STORED without checking its signature, for values checked
+// when they were stored
+func Parse(stored string) Value {
+	if len(stored) < headerLen || stored[0] != signedMark {
+		return Value{Data: stored}
+	}
+	public, err1 := hex.DecodeString(stored[1 : 1+keyDigits])
+	seq, err2 := strconv.ParseUint(stored[1+keyDigits:1+keyDigits+seqDigits], 16, 64)
+	sig, err3 := hex.DecodeString(stored[1+keyDigits+seqDigits : headerLen])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return Value{Data: stored}
+	}
+	return Value{Data: stored[headerLen:], Publisher: public, Seq: int64(seq), Sig: sig}
+}
+
+// Reason names the error ERR returned by Check, as recorded in the metrics
+func Reason(err error) string {
+	switch err {
+	case ErrBadSignature:
+		return "bad_signature"
+	case ErrUnsigned:
+		return "unsigned"
+	case ErrPublisher:
+		return "publisher"
+	}
+	return "other"
+}
+
+// Check tells why a node refuses to store NEW under KEY in place of OLD,
+// OLD being empty if the key is not stored, nil if the write is accepted.
+// A signed value is only replaced by one of the same publisher, and
+// unsigned values are refused altogether if REQUIRE is set
+func Check(key, old, new string, require bool) (Value, error) {
+	v, err := Open(key, new)
+	if err != nil {
+		return v, err
+	}
+	if !v.Signed() && require {
+		return v, ErrUnsigned
+	}
+	// OLD was checked when it was stored
+	if o := Parse(old); o.Signed() && !bytes.Equal(o.Publisher, v.Publisher) {
+		return v, ErrPublisher
+	}
+	return v, nil
+}
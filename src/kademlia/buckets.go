@@ -1,7 +1,10 @@
 package kademlia
 
 import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/identity"
 	"container/list"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
@@ -10,12 +13,14 @@ import (
 type Contact struct {
 	Addr Address
 	ID   Identifer
+	// binds ID to the address if the nodes have identities
+	Proof *identity.Proof
 }
 
-func NewContact(addr Address) *Contact {
+func NewContact(addr Address, cfg *Config) *Contact {
 	ret := new(Contact)
 	ret.Addr = addr
-	ret.ID = hash(addr)
+	ret.ID = cfg.hash(addr)
 	return ret
 }
 
@@ -24,24 +29,29 @@ type kBucket struct {
 	timeStamp   time.Time
 	contacts    *list.List
 	bucketRange IDRange
+	// width of the identifiers
+	width int
+	clock clock.Clock
 
 	contLock sync.RWMutex
 }
 
-func NewKBucket(initRange *IDRange) *kBucket {
+func NewKBucket(initRange *IDRange, width int, clk clock.Clock) *kBucket {
 	ret := new(kBucket)
 	ret.contacts = list.New()
-	ret.timeStamp = time.Now()
+	ret.width = width
+	ret.clock = clk
+	ret.timeStamp = clk.Now()
 	if initRange != nil {
 		ret.bucketRange = *initRange
 	} else {
-		ret.bucketRange = IDRange{low: NewID(0), high: IDpow2(M)}
+		ret.bucketRange = IDRange{bits: width}
 	}
 	return ret
 }
 
 func (b *kBucket) Touch() {
-	b.timeStamp = time.Now()
+	b.timeStamp = b.clock.Now()
 }
 
 func (b *kBucket) Contain(id Identifer) bool {
@@ -116,11 +126,11 @@ func (b *kBucket) UpdateContact(contact Contact) {
 }
 
 func (b *kBucket) Split() (*kBucket, *kBucket) {
-	mid := b.bucketRange.midpoint()
-	k1 := NewKBucket(&IDRange{low: b.bucketRange.low, high: mid})
-	k2 := NewKBucket(&IDRange{low: mid, high: b.bucketRange.high})
+	lower, upper := b.bucketRange.split()
+	k1 := NewKBucket(&lower, b.width, b.clock)
+	k2 := NewKBucket(&upper, b.width, b.clock)
 	b.ForEachContact(func(c Contact) {
-		if c.ID.Cmp(mid) < 0 {
+		if lower.contain(c.ID) {
 			k1.AddContact(c)
 		} else {
 			k2.AddContact(c)
@@ -131,12 +141,12 @@ func (b *kBucket) Split() (*kBucket, *kBucket) {
 
 func (b *kBucket) Depth() int {
 	if b.Size() == 0 {
-		return M
+		return b.width
 	} else {
-		len := M
+		len := b.width
 		fir := b.LeastRecent().ID
 		b.ForEachContact(func(c Contact) {
-			len = minInt(len, SharedPrefixLen(fir, c.ID))
+			len = minInt(len, SharedPrefixLen(fir, c.ID, b.width))
 		})
 		return len
 	}
@@ -144,18 +154,27 @@ func (b *kBucket) Depth() int {
 
 // Routing Table of Kademlia Protocol
 type bucketList struct {
+	cfg      *Config
 	proto    *protocol
 	host     Contact
 	buckets  *list.List
 	buckLock sync.RWMutex
+	// source of the random identifiers of bucket refreshes
+	rand *rand.Rand
 }
 
 func NewBucketList(addr Address, pro *protocol) *bucketList {
 	ret := new(bucketList)
 	ret.proto = pro
-	ret.host = *NewContact(addr)
+	ret.cfg = &pro.node.cfg
+	ret.host = hostContact(addr, ret.cfg)
 	ret.buckets = list.New()
-	ret.buckets.PushBack(NewKBucket(nil))
+	ret.buckets.PushBack(NewKBucket(nil, ret.cfg.M, ret.cfg.Clock))
+	if seed := ret.cfg.Seed; seed != 0 {
+		ret.rand = rand.New(rand.NewSource(seed))
+	} else {
+		ret.rand = IDGenerator
+	}
 	return ret
 }
 
@@ -187,16 +206,21 @@ func (b *bucketList) AddContact(c Contact) {
 	if c.Addr == b.host.Addr {
 		return
 	}
+	if b.cfg.Identity != nil && !c.verified(b.cfg) {
+		identityFailures.With(b.host.Addr).Inc()
+		return
+	}
 	ele, buck := b.FindBucket(c.ID)
 	if buck != nil {
 		if v, _ := buck.FindContact(c); v != nil {
 			buck.UpdateContact(c)
 		} else {
-			if buck.Size() < K {
+			if buck.Size() < b.cfg.K {
 				buck.AddContact(c)
 			} else {
-				if buck.Contain(b.host.ID) || buck.Depth()%B != 0 {
+				if buck.Contain(b.host.ID) || buck.Depth()%b.cfg.B != 0 {
 					k1, k2 := buck.Split()
+					bucketSplits.With(b.host.Addr).Inc()
 					if k1.Contain(c.ID) {
 						k1.AddContact(c)
 					} else {
@@ -207,8 +231,9 @@ func (b *bucketList) AddContact(c Contact) {
 					b.buckets.Remove(ele)
 				} else {
 					oldest := buck.LeastRecent()
-					if !b.proto.rpcPing(oldest) {
+					if !b.proto.alive(oldest) {
 						buck.EvictContact(oldest)
+						bucketEvictions.With(b.host.Addr).Inc()
 						buck.AddContact(c)
 					} else {
 						buck.UpdateContact(oldest)
@@ -261,7 +286,7 @@ func (b *bucketList) GetClosestContacts(id Identifer, num int) []ContWithDist {
 func (b *bucketList) ContactIndex(c Contact) int {
 	cnt := 0
 	b.ForEachBucket(func(bk *kBucket) {
-		if bk.bucketRange.high.Cmp(c.ID) <= 0 {
+		if bk.bucketRange.below(c.ID) {
 			cnt += bk.Size()
 		}
 		if bk.bucketRange.contain(c.ID) {
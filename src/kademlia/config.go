@@ -0,0 +1,163 @@
+package kademlia
+
+import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/ident"
+	"DHT-2022/src/identity"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/network"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config tunes the routing and maintenance of a node, zero fields take
+// the default values given by the constants of the same names
+type Config struct {
+	// width of the identifiers, the nodes of a network must agree on it
+	// and on Hash
+	M int
+	// hashes keys and addresses into identifiers, sha1 by default
+	Hash ident.Hash
+	// size of a k-bucket and of the lookup results
+	K int
+	// lookup RPCs in flight at the same time
+	Alpha int
+	// a k-bucket away from the host is split every B levels only
+	B int
+
+	ExpireTime        time.Duration
+	RefreshInterval   time.Duration
+	RepublishInterval time.Duration
+
+	LookupTimeout time.Duration
+	PingTimeout   time.Duration
+	DialTimeout   time.Duration
+
+	// where and how much the node logs, warnings to stderr by default
+	Log logging.Config
+
+	// carries the traffic of the node, TCP by default
+	Transport network.Transport
+	// secures the transport with TLS if set
+	TLS *network.TLSConfig
+	// key shared by the cluster signing every request, the requests not
+	// signed with it are rejected. Nothing is signed if empty
+	ClusterKey []byte
+	// keypair the ID of the node is derived from and its values are
+	// signed with, the ID is the hash of the address if nil. The nodes of
+	// a network must agree on whether they have identities
+	Identity *identity.Identity
+	// stores of values not signed by their publishers are refused
+	RequireSigned bool
+	// faults injected into the calls of the node, for tests
+	Faults *network.Faults
+
+	// the real clock by default
+	Clock clock.Clock
+	// no maintenance goroutines are run and remote calls are made one at
+	// a time, the tasks are run by calling Maintain instead, e.g. by a
+	// simulator driving the clock
+	Manual bool
+	// seeds the random identifiers of bucket refreshes, 0 for a seed taken
+	// from the time
+	Seed int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		M:                 M,
+		K:                 K,
+		Alpha:             Alpha,
+		B:                 B,
+		ExpireTime:        ExpireTime,
+		RefreshInterval:   RefreshInterval,
+		RepublishInterval: RepublishInterval,
+		LookupTimeout:     LookupTimeOut,
+		PingTimeout:       PingTimeOut,
+		DialTimeout:       DialTimeOut,
+		Transport:         network.TCP{},
+		Clock:             clock.Real{},
+	}
+}
+
+func (c Config) withDefaults() Config {
+	def := DefaultConfig()
+	if c.M == 0 {
+		c.M = def.M
+	}
+	if c.K == 0 {
+		c.K = def.K
+	}
+	if c.Alpha == 0 {
+		c.Alpha = def.Alpha
+	}
+	if c.B == 0 {
+		c.B = def.B
+	}
+	if c.ExpireTime == 0 {
+		c.ExpireTime = def.ExpireTime
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = def.RefreshInterval
+	}
+	if c.RepublishInterval == 0 {
+		c.RepublishInterval = def.RepublishInterval
+	}
+	if c.LookupTimeout == 0 {
+		c.LookupTimeout = def.LookupTimeout
+	}
+	if c.PingTimeout == 0 {
+		c.PingTimeout = def.PingTimeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = def.DialTimeout
+	}
+	if c.Transport == nil {
+		c.Transport = def.Transport
+	}
+	if c.Clock == nil {
+		c.Clock = def.Clock
+	}
+	return c
+}
+
+// Validate reports the first field out of range, zero fields are taken
+// as valid since they take the default values
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	switch {
+	case c.M < 1 || c.M > M:
+		return fmt.Errorf("invalid config: M %d out of [1, %d]", c.M, M)
+	case c.K < 1:
+		return fmt.Errorf("invalid config: K %d less than 1", c.K)
+	case c.Alpha < 1 || c.Alpha > c.K:
+		return fmt.Errorf("invalid config: Alpha %d out of [1, K]", c.Alpha)
+	case c.B < 1 || c.B > c.M:
+		return fmt.Errorf("invalid config: B %d out of [1, M]", c.B)
+	case c.ExpireTime < 0 || c.RefreshInterval < 0 || c.RepublishInterval < 0:
+		return errors.New("invalid config: negative interval")
+	case c.RepublishInterval > c.ExpireTime:
+		// data would expire before being republished
+		return errors.New("invalid config: RepublishInterval longer than ExpireTime")
+	case c.LookupTimeout < 0 || c.PingTimeout < 0 || c.DialTimeout < 0:
+		return errors.New("invalid config: negative timeout")
+	}
+	if err := c.Hash.Validate(); err != nil {
+		return err
+	}
+	if len(c.ClusterKey) > 0 && len(c.ClusterKey) < network.MinClusterKey {
+		return fmt.Errorf("invalid config: ClusterKey shorter than %d bytes", network.MinClusterKey)
+	}
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	return c.Log.Validate()
+}
+
+// identifier of KEY, its hash cut to M bits
+func (c *Config) hash(key string) Identifer {
+	return c.Hash.ID([]byte(key), c.M)
+}
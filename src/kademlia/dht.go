@@ -1,13 +1,54 @@
 package kademlia
 
+import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/identity"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/network"
+	"DHT-2022/src/store"
+	"context"
+	"errors"
+)
+
 type KademliaNode struct {
 	impl *kademliaImpl
 }
 
-func NewKademliaNode(addr Address) *KademliaNode {
+var _ dht.Node = (*KademliaNode)(nil)
+
+// NewKademliaNode sets up a node at ADDR tuned by CFG, zero fields of CFG
+// take the default values
+func NewKademliaNode(addr Address, cfg Config) (*KademliaNode, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	logs, err := logging.New(cfg.Log)
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	if cfg.Transport, err = network.Secure(cfg.Transport, cfg.TLS); err != nil {
+		return nil, err
+	}
 	ret := &KademliaNode{new(kademliaImpl)}
-	ret.impl.initialize(addr)
-	return ret
+	ret.impl.initialize(addr, cfg, logs)
+	return ret, nil
+}
+
+// SetStorage replaces the storage engines of the node with the ones given
+// by OPENER, it should be called before the node joins a network
+func (k *KademliaNode) SetStorage(opener store.Opener) error {
+	k.impl.closeStorage()
+	return k.impl.openStorage(opener)
+}
+
+func (k *KademliaNode) Addr() string {
+	return k.impl.addr
+}
+
+// Online reports whether the node is in a network
+func (k *KademliaNode) Online() bool {
+	return k.impl.online
 }
 
 func (k *KademliaNode) Run() {
@@ -20,23 +61,38 @@ func (k *KademliaNode) Create() {
 
 func (k *KademliaNode) Join(addr Address) bool {
 	if k.impl.online {
-		logger(k.impl.addr).Warn("node have joined")
+		k.impl.logger().Warn("node have joined")
 		return false
 	}
 	if !k.impl.ping(addr) {
-		logger(k.impl.addr).Warn("invalid bootstrapping node")
+		k.impl.logger().Warn("invalid bootstrapping node")
 		return false
 	}
-	k.impl.router.AddContact(*NewContact(addr))
-	k.impl.iterativeFindNode(k.impl.addr)
+	boot := *NewContact(addr, &k.impl.cfg)
+	if k.impl.cfg.Identity != nil {
+		// the ID of the bootstrapping node is the one it proves
+		var err error
+		if boot, err = k.impl.proto.rpcContact(addr); err != nil || !boot.verified(&k.impl.cfg) {
+			k.impl.logger().Warn("bootstrapping node failed to prove its identity")
+			return false
+		}
+	}
+	k.impl.router.AddContact(boot)
+	k.impl.iterativeFindNode(k.impl.router.host.ID)
 	k.impl.maintain()
 	k.impl.online = true
 	return true
 }
 
+// Maintain runs the maintenance tasks due by the clock of the node, it is
+// for nodes configured as Manual and does nothing before Join
+func (k *KademliaNode) Maintain() {
+	k.impl.round()
+}
+
 func (k *KademliaNode) Quit() {
 	if !k.impl.online {
-		logger(k.impl.addr).Warn("node have quitted")
+		k.impl.logger().Warn("node have quitted")
 		return
 	}
 	k.impl.shutdown()
@@ -52,18 +108,62 @@ func (k *KademliaNode) Ping(addr Address) bool {
 	return k.impl.ping(addr)
 }
 
+// Put tells whether VALUE is stored, a value kept by the node alone is
+// republished later and reported by TryPut only, with dht.ErrReplicaWrite
 func (k *KademliaNode) Put(key KeyType, value ValueType) bool {
-	k.impl.iterativeStore(key, value)
-	return true
+	err := k.TryPut(key, value)
+	return err == nil || errors.Is(err, dht.ErrReplicaWrite)
 }
 
 func (k *KademliaNode) Get(key KeyType) (bool, ValueType) {
-	ok, value := k.impl.iterativeFindValue(key)
-	return ok, value
+	value, err := k.TryGet(key)
+	return err == nil, value
 }
 
 func (k *KademliaNode) Delete(key KeyType) bool {
 	// under kademlia protocol, the DELETE operation is ill-supported
-	// here just leave it out for simplicity
+	// here just leave it out for simplicity, TryDelete tells so
 	return true
 }
+
+func (k *KademliaNode) TryPut(key KeyType, value ValueType) error {
+	return k.PutCtx(context.Background(), key, value)
+}
+
+func (k *KademliaNode) TryGet(key KeyType) (ValueType, error) {
+	return k.GetCtx(context.Background(), key)
+}
+
+func (k *KademliaNode) TryDelete(key KeyType) error {
+	return k.DeleteCtx(context.Background(), key)
+}
+
+func (k *KademliaNode) PutCtx(ctx context.Context, key KeyType, value ValueType) error {
+	return k.impl.iterativeStore(ctx, key, value)
+}
+
+// GetCtx finds the value of KEY, a signed value is stripped of its
+// signature and is not returned if the signature does not hold
+func (k *KademliaNode) GetCtx(ctx context.Context, key KeyType) (ValueType, error) {
+	v, err := k.impl.getSigned(ctx, key)
+	return v.Data, err
+}
+
+// PutSigned publishes VALUE under KEY like PutCtx, signed with the
+// identity of the node. The nodes refuse to replace it later with a value
+// not signed by the node
+func (k *KademliaNode) PutSigned(ctx context.Context, key KeyType, value ValueType) error {
+	return k.impl.putSigned(ctx, key, value)
+}
+
+// GetSigned finds the value of KEY like GetCtx, the value tells its
+// publisher if it is signed
+func (k *KademliaNode) GetSigned(ctx context.Context, key KeyType) (identity.Value, error) {
+	return k.impl.getSigned(ctx, key)
+}
+
+// DeleteCtx always fails with dht.ErrNotSupported, under kademlia protocol
+// a value only goes away once it expires
+func (k *KademliaNode) DeleteCtx(ctx context.Context, key KeyType) error {
+	return dht.NewError("delete", key, dht.ErrNotSupported, nil)
+}
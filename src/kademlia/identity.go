@@ -0,0 +1,85 @@
+package kademlia
+
+import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/identity"
+	"context"
+	"errors"
+	"time"
+)
+
+// nodes with identities take their IDs from their public keys, each
+// contact carries the proof binding its ID to its address so that it can
+// be checked by whoever it is passed to
+
+// the contact of the node at ADDR, holding the identity of CFG if any
+func hostContact(addr Address, cfg *Config) Contact {
+	id := cfg.Identity
+	if id == nil {
+		return *NewContact(addr, cfg)
+	}
+	proof := id.Prove(addr)
+	return Contact{Addr: addr, ID: cfg.Hash.ID(id.Public(), cfg.M), Proof: &proof}
+}
+
+// whether the contact proves the key its ID is taken from, as hashed by
+// the nodes of CFG
+func (c Contact) verified(cfg *Config) bool {
+	return c.Proof != nil && c.Proof.Verify(c.Addr) &&
+		c.ID.Cmp(cfg.Hash.ID(c.Proof.Key, cfg.M)) == 0
+}
+
+// a store older than the value of the same publisher is dropped quietly,
+// as the nodes keep the newest one
+var errOlder = errors.New("older than the value stored")
+
+// store VAL under KEY into S, refusing it if its signature does not hold,
+// if it replaces a value signed by another publisher, or if it is not
+// signed while the node requires so
+func (k *kademliaImpl) checkedStore(s *storage, key KeyType, val ValueType, expire time.Duration) error {
+	// a value published by the node is kept apart from the ones stored by
+	// the others, and is checked against too
+	published, _ := k.origin.Get(key)
+	err := s.PutChecked(key, val, expire, func(old ValueType) error {
+		if old == NIL {
+			old = published
+		}
+		v, err := identity.Check(key, old, val, k.cfg.RequireSigned)
+		if err == nil && v.Signed() {
+			if o := identity.Parse(old); o.Signed() && v.Seq < o.Seq {
+				return errOlder
+			}
+		}
+		return err
+	})
+	if err == nil || err == errOlder {
+		return nil
+	}
+	k.logger().WithField("key", key).WithError(err).Warn("store refused")
+	rejectedWrites.With(k.addr, identity.Reason(err)).Inc()
+	return dht.ErrUnauthorized
+}
+
+// publish VALUE signed by the node under KEY, a later write replaces it
+// only if signed by the node too
+func (k *kademliaImpl) putSigned(ctx context.Context, key KeyType, value ValueType) error {
+	if k.cfg.Identity == nil {
+		return dht.NewError("put", key, dht.ErrUnauthorized, identity.ErrNoIdentity)
+	}
+	seq := k.cfg.Clock.Now().UnixNano()
+	return k.iterativeStore(ctx, key, k.cfg.Identity.SignValue(key, value, seq))
+}
+
+// find the value of KEY and check its signature
+func (k *kademliaImpl) getSigned(ctx context.Context, key KeyType) (identity.Value, error) {
+	val, err := k.iterativeFindValue(ctx, key)
+	if err != nil {
+		return identity.Value{}, err
+	}
+	v, err := identity.Open(key, val)
+	if err != nil {
+		k.logger().WithField("key", key).WithError(err).Warn("value with invalid signature")
+		return identity.Value{}, dht.NewError("get", key, dht.ErrUnauthorized, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,97 @@
+package kademlia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NodeInfo is a snapshot of the state of a node, for debugging
+type NodeInfo struct {
+	Addr    Address
+	ID      string
+	Online  bool
+	Buckets []BucketInfo
+
+	OriginKeys    int
+	ReplicateKeys int
+	CacheKeys     int
+}
+
+// BucketInfo describes a k-bucket covering the identifiers in [LOW, HIGH),
+// both in hex as the ID of the node, HIGH wraps to 0 for the last bucket.
+// Its contacts are listed from the least recently seen. LASTSEEN holds the
+// time each contact last answered the node, zero if it has not since the
+// failure detector knows it
+type BucketInfo struct {
+	Low, High string
+	TimeStamp time.Time
+	Contacts  []Address
+	LastSeen  []time.Time
+}
+
+func (p *protocol) HandleGetNodeInfo(_ bool, reply *NodeInfo) error {
+	k := p.node
+	info := NodeInfo{
+		Addr:   k.addr,
+		ID:     k.router.host.ID.String(),
+		Online: k.online,
+	}
+	k.router.ForEachBucket(func(b *kBucket) {
+		bucket := BucketInfo{
+			Low:       b.bucketRange.low.String(),
+			High:      b.bucketRange.high().String(),
+			TimeStamp: b.timeStamp,
+		}
+		b.ForEachContact(func(c Contact) {
+			seen, _ := k.detector.LastSeen(c.Addr)
+			bucket.Contacts = append(bucket.Contacts, c.Addr)
+			bucket.LastSeen = append(bucket.LastSeen, seen)
+		})
+		info.Buckets = append(info.Buckets, bucket)
+	})
+	k.storeLock.RLock()
+	info.OriginKeys = k.origin.Len()
+	info.ReplicateKeys = k.replicate.Len()
+	info.CacheKeys = k.cache.Len()
+	k.storeLock.RUnlock()
+	*reply = info
+	return nil
+}
+
+// String dumps the state of the node, empty buckets are left out
+func (i NodeInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "node %s id %s online %v\n", i.Addr, i.ID, i.Online)
+	for k, bucket := range i.Buckets {
+		if len(bucket.Contacts) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  bucket[%d] [%s, %s) touched %s\n", k, bucket.Low, bucket.High,
+			bucket.TimeStamp.Format(time.RFC3339))
+		for j, addr := range bucket.Contacts {
+			seen := "never"
+			if j < len(bucket.LastSeen) && !bucket.LastSeen[j].IsZero() {
+				seen = bucket.LastSeen[j].Format(time.RFC3339)
+			}
+			fmt.Fprintf(&b, "    %s seen %s\n", addr, seen)
+		}
+	}
+	fmt.Fprintf(&b, "  keys %d origin, %d replicate, %d cache\n", i.OriginKeys, i.ReplicateKeys, i.CacheKeys)
+	return b.String()
+}
+
+// Info gives the state of the node
+func (k *KademliaNode) Info() NodeInfo {
+	var info NodeInfo
+	k.impl.proto.HandleGetNodeInfo(true, &info)
+	return info
+}
+
+// RemoteInfo asks the node at ADDR for its state
+func (k *KademliaNode) RemoteInfo(ctx context.Context, addr Address) (NodeInfo, error) {
+	var info NodeInfo
+	err := k.impl.callCtx(ctx, addr, "KademliaService", "HandleGetNodeInfo", true, &info)
+	return info, err
+}
@@ -1,11 +1,19 @@
 package kademlia
 
 import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/network"
+	"DHT-2022/src/store"
 	"container/heap"
+	"context"
+	"errors"
 	"math"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type kademliaImpl struct {
@@ -14,6 +22,16 @@ type kademliaImpl struct {
 	origin    *storage
 	replicate *storage
 	cache     *storage
+	storeLock sync.RWMutex
+	// gives the engines, kept to reopen them when the node leaves
+	opener store.Opener
+	cfg    Config
+
+	// next due time of each maintenance task, in manual mode
+	dueLock      sync.Mutex
+	refreshDue   time.Time
+	republishDue time.Time
+	expireDue    time.Time
 }
 
 type LookupRet struct {
@@ -23,35 +41,87 @@ type LookupRet struct {
 	Value   ValueType
 }
 
-type LookupRpc func(Contact, KeyType, Identifer) (LookupRet, error)
+type LookupRpc func(context.Context, Contact, KeyType, Identifer) (LookupRet, error)
 
-func (k *kademliaImpl) initialize(address Address) {
+func (k *kademliaImpl) initialize(address Address, cfg Config, logs *log.Logger) {
 	k.addr = address
+	k.logs = logs
+	k.cfg = cfg
 	k.proto = &protocol{k}
 	k.online = false
 	k.quitSignal = make(chan bool)
+	k.transport = cfg.Transport
+	k.pingTimeOut = cfg.PingTimeout
+	k.clock = cfg.Clock
+	k.pool = newPool(address, cfg)
+	k.conns.Auth = network.NewAuth(cfg.ClusterKey, address, cfg.Clock)
+	k.detector = newDetector(cfg.RefreshInterval, cfg.Clock)
 	k.router = NewBucketList(address, k.proto)
-	k.origin = NewStorage()
-	k.cache = NewStorage()
-	k.replicate = NewStorage()
+	k.openStorage(store.Memory())
+	k.exportStores()
 }
 
 func (k *kademliaImpl) reset() {
 	k.quitSignal = make(chan bool)
+	k.pool.Flush()
+	k.detector.Reset()
 	k.router = NewBucketList(k.addr, k.proto)
-	k.origin = NewStorage()
-	k.cache = NewStorage()
-	k.replicate = NewStorage()
+	k.closeStorage()
+	if err := k.openStorage(k.opener); err != nil {
+		k.errLogger(err).Error("reopen storage failed")
+	}
+}
+
+// open the ORIGINATOR, REPLICATE and CACHE storages with engines given by OPENER
+func (k *kademliaImpl) openStorage(opener store.Opener) error {
+	engines := make([]store.Engine, 0, 3)
+	for _, name := range []string{"origin", "replicate", "cache"} {
+		e, err := opener(name)
+		if err != nil {
+			for _, v := range engines {
+				v.Close()
+			}
+			return err
+		}
+		engines = append(engines, e)
+	}
+	k.storeLock.Lock()
+	k.opener = opener
+	k.origin = NewStorage(engines[0], k.cfg.ExpireTime, k.cfg.Clock, k.logger())
+	k.replicate = NewStorage(engines[1], k.cfg.ExpireTime, k.cfg.Clock, k.logger())
+	k.cache = NewStorage(engines[2], k.cfg.ExpireTime, k.cfg.Clock, k.logger())
+	k.storeLock.Unlock()
+	return nil
+}
+
+func (k *kademliaImpl) closeStorage() {
+	k.origin.Close()
+	k.replicate.Close()
+	k.cache.Close()
 }
 
-func (k *kademliaImpl) Lookup(key KeyType, id Identifer, rpcFunc LookupRpc) (bool, []ContWithDist, ValueType, error) {
-	ch := make(chan LookupRet, Alpha)
+// iterative lookup for ID, it gives up as soon as CTX is done, and the
+// remote calls still in flight are aborted on return
+func (k *kademliaImpl) Lookup(ctx context.Context, key KeyType, id Identifer, rpcFunc LookupRpc) (found bool, conts []ContWithDist, val ValueType, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := k.cfg.Clock.Now()
+	ch := make(chan LookupRet, k.cfg.Alpha)
 	visit := make(map[Address]bool)
 	pending := new(ContactHeap)
 	retList := []ContWithDist{}
 
 	visit[k.addr] = true
-	initial := k.router.GetClosestContacts(id, K)
+	initial := k.router.GetClosestContacts(id, k.cfg.K)
+	answered, timedOut := 0, 0
+	defer func() {
+		outcome := "ok"
+		if err != nil {
+			outcome = "failed"
+		}
+		lookupHops.With(k.addr).Observe(float64(answered))
+		lookupSeconds.With(k.addr, outcome).ObserveDuration(k.cfg.Clock.Since(start))
+	}()
 	*pending = append(*pending, initial...)
 	heap.Init(pending)
 
@@ -62,103 +132,144 @@ func (k *kademliaImpl) Lookup(key KeyType, id Identifer, rpcFunc LookupRpc) (boo
 			if _, ok := visit[c.Addr]; !ok {
 				visit[c.Addr] = true
 				atomic.AddInt32(&inFlight, 1)
-				go func() {
-					if res, err := rpcFunc(c, key, id); err == nil {
+				call := func() {
+					if res, err := rpcFunc(ctx, c, key, id); err == nil {
 						ch <- res
 					} else {
 						atomic.AddInt32(&inFlight, -1)
 					}
-				}()
+				}
+				if k.cfg.Manual {
+					call()
+				} else {
+					go call()
+				}
 			}
 		}
 	}
 
-	sendRpcUpto(Alpha)
+	sendRpcUpto(k.cfg.Alpha)
 	for atomic.LoadInt32(&inFlight) > 0 {
+		var timeout <-chan time.Time
+		if !k.cfg.Manual {
+			// calls made inline in manual mode have nothing left to time out
+			timeout = k.cfg.Clock.After(k.cfg.LookupTimeout)
+		}
 		select {
 		case res := <-ch:
+			answered++
 			if res.Found {
 				// retCont := minInSlice(retList, res.FoundBy)
 				return true, res.Cont, res.Value, nil
 			}
 			for _, v := range res.Cont {
+				if k.cfg.Identity != nil && !v.Cont.verified(&k.cfg) {
+					identityFailures.With(k.addr).Inc()
+					continue
+				}
 				if _, ok := visit[v.Cont.Addr]; !ok {
 					retList = append(retList, v)
 					heap.Push(pending, v)
 				}
 			}
-		case <-time.After(LookupTimeOut):
-			break
+		case <-timeout:
+			timedOut++
+		case <-ctx.Done():
+			return false, retList, NIL, dht.FromContext(ctx.Err())
 		}
 		atomic.AddInt32(&inFlight, -1)
-		sendRpcUpto(Alpha)
+		sendRpcUpto(k.cfg.Alpha)
 	}
 	// tch <- true
 
 	sort.Slice(retList, func(i, j int) bool {
 		return retList[i].Dist.Cmp(retList[j].Dist) < 0
 	})
-	retList = retList[:minInt(K, len(retList))]
+	retList = retList[:minInt(k.cfg.K, len(retList))]
+	if len(initial) > 0 && answered == 0 {
+		// none of the known contacts can be reached
+		if timedOut > 0 {
+			return false, retList, NIL, dht.NewError("lookup", key, dht.ErrNoRoute, dht.ErrTimeout)
+		}
+		return false, retList, NIL, dht.ErrNoRoute
+	}
 	return false, retList, NIL, nil
 }
 
 // respond to STORE RPCs
-func (k *kademliaImpl) primitiveStore(sender Contact, key KeyType, val ValueType, cached bool, expireTime time.Duration) {
+func (k *kademliaImpl) primitiveStore(sender Contact, key KeyType, val ValueType, cached bool, expireTime time.Duration) error {
+	var err error
 	if cached {
-		k.cache.Put(key, val, expireTime)
+		err = k.checkedStore(k.cache, key, val, expireTime)
 	} else {
 		k.TransferDataToNewNodes(sender)
-		k.replicate.Put(key, val, ExpireTime)
+		err = k.checkedStore(k.replicate, key, val, k.cfg.ExpireTime)
 	}
+	k.detector.Success(sender.Addr)
 	k.router.AddContact(sender)
+	return err
 }
 
 // respond to FIND_NODE RPCs
 func (k *kademliaImpl) primitiveFindNode(sender Contact, id Identifer) []ContWithDist {
 	k.TransferDataToNewNodes(sender)
 	// fmt.Printf("ADDING sender %s to %s\n", sender.Addr, k.addr)
+	k.detector.Success(sender.Addr)
 	k.router.AddContact(sender)
-	return k.router.GetClosestContacts(id, K)
+	return k.router.GetClosestContacts(id, k.cfg.K)
 }
 
 // respond to FIND_VALUE RPCs
 func (k *kademliaImpl) primitiveFindValue(sender Contact, key KeyType) (bool, Contact, []ContWithDist, ValueType) {
 	k.TransferDataToNewNodes(sender)
+	k.detector.Success(sender.Addr)
 	k.router.AddContact(sender)
 	if v, ok := k.replicate.Get(key); ok {
 		return true, k.router.host, []ContWithDist{}, v
 	} else if v, ok := k.cache.Get(key); ok {
 		return true, k.router.host, []ContWithDist{}, v
 	} else {
-		return false, Contact{}, k.router.GetClosestContacts(hash(key), K), NIL
+		return false, Contact{}, k.router.GetClosestContacts(k.cfg.hash(key), k.cfg.K), NIL
 	}
 }
 
 // store data in ORIGINATOR storage and spread it
-func (k *kademliaImpl) iterativeStore(key KeyType, val ValueType) {
-	k.router.Touch(hash(key))
+func (k *kademliaImpl) iterativeStore(ctx context.Context, key KeyType, val ValueType) error {
+	k.router.Touch(k.cfg.hash(key))
 	k.origin.Put(key, val, 0)
-	k.TransferDataToCloserNodes(key, val, false)
+	err := k.TransferDataToCloserNodes(ctx, key, val, false)
+	if errors.Is(err, dht.ErrUnauthorized) {
+		// not to be republished
+		k.origin.Remove(key)
+		return dht.Wrap(ctx, "put", key, dht.ErrUnauthorized, nil)
+	}
+	if err != nil {
+		return dht.Wrap(ctx, "put", key, dht.ErrReplicaWrite, err)
+	}
+	return nil
 }
 
 // start an iterative lookup process for nodes
-func (k *kademliaImpl) iterativeFindNode(addr Address) []ContWithDist {
-	k.router.Touch(hash(addr))
-	_, contacts, _, _ := k.proto.node.Lookup(NIL, hash(addr), k.proto.rpcFindNode)
+func (k *kademliaImpl) iterativeFindNode(id Identifer) []ContWithDist {
+	k.router.Touch(id)
+	_, contacts, _, _ := k.proto.node.Lookup(context.Background(), NIL, id, k.proto.rpcFindNode)
 	return contacts
 }
 
 // start an iterative lookup process for a value
-func (k *kademliaImpl) iterativeFindValue(key KeyType) (bool, ValueType) {
-	k.router.Touch(hash(key))
+func (k *kademliaImpl) iterativeFindValue(ctx context.Context, key KeyType) (ValueType, error) {
+	k.router.Touch(k.cfg.hash(key))
 	if v, ok := k.origin.Get(key); ok {
-		return true, v
+		return v, nil
 	} else if v, ok := k.replicate.Get(key); ok {
-		return true, v
+		return v, nil
 	} else if v, ok := k.cache.Get(key); ok {
-		return true, v
+		return v, nil
 	} else {
-		found, contacts, val, _ := k.Lookup(key, hash(key), k.proto.rpcFindValue)
+		found, contacts, val, err := k.Lookup(ctx, key, k.cfg.hash(key), k.proto.rpcFindValue)
+		if err != nil {
+			return NIL, dht.Wrap(ctx, "get", key, dht.ErrNoRoute, err)
+		}
 		if found {
 			// cache data to cloest nodes that doesnt have it
 			upto := minInt(3, len(contacts))
@@ -168,12 +279,12 @@ func (k *kademliaImpl) iterativeFindValue(key KeyType) (bool, ValueType) {
 					idx1 := k.router.ContactIndex(target)
 					idx2 := k.router.ContactIndex(k.router.host)
 					sepNum := minInt(absInt(idx1-idx2), 20)
-					expireTime := ExpireTime / time.Duration(math.Pow(2, float64(sepNum)))
-					k.proto.rpcStore(target, key, val, true, expireTime)
+					expireTime := k.cfg.ExpireTime / time.Duration(math.Pow(2, float64(sepNum)))
+					k.proto.rpcStore(ctx, target, key, val, true, expireTime)
 				}
 			}
-			return true, val
+			return val, nil
 		}
-		return false, NIL
+		return NIL, dht.NewError("get", key, dht.ErrNotFound, nil)
 	}
 }
@@ -1,6 +1,12 @@
 package kademlia
 
 import (
+	"DHT-2022/src/dht"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,73 +16,131 @@ import (
 // used for transfering data to newly joined nodes
 func (k *kademliaImpl) TransferDataToNewNodes(sender Contact) {
 	if _, v := k.router.FindBucket(sender.ID); v == nil {
-		ch := make(chan bool, Alpha)
+		var keys []KeyType
+		vals := make(map[KeyType]ValueType)
 		k.replicate.ForEachKeyValue(
 			func(key KeyType, val ValueType) {
-				mindis := k.router.GetClosestDistance(hash(key))
-				if Distance(hash(key), hash(k.addr)).Cmp(mindis) < 0 {
-					go func() {
-						ch <- true
-						k.proto.rpcStore(k.router.host, key, val, false, 0)
-						<-ch
-					}()
+				mindis := k.router.GetClosestDistance(k.cfg.hash(key))
+				if Distance(k.cfg.hash(key), k.router.host.ID).Cmp(mindis) < 0 {
+					keys = append(keys, key)
+					vals[key] = val
 				}
 			},
 		)
+		// the stores are made in the order of the keys in manual mode, so
+		// that a simulation runs the same every time
+		sort.Strings(keys)
+		ch := make(chan bool, k.cfg.Alpha)
+		for _, key := range keys {
+			if k.cfg.Manual {
+				k.proto.rpcStore(context.Background(), k.router.host, key, vals[key], false, 0)
+				continue
+			}
+			go func(key KeyType, val ValueType) {
+				ch <- true
+				k.proto.rpcStore(context.Background(), k.router.host, key, val, false, 0)
+				<-ch
+			}(key, vals[key])
+		}
 	}
 }
 
-// transfer a (key, value) data pair to nodes that closer to hash(KEY),
+// transfer a (key, value) data pair to nodes that closer to the hash of KEY,
 // enable node lookup by setting ENABLELOOKUP to true
 //
-// used for spreading data to the right nodes for them
-func (k *kademliaImpl) TransferDataToCloserNodes(key KeyType, val ValueType, enableLookup bool) {
-	_, b := k.router.FindBucket(hash(key))
+// used for spreading data to the right nodes for them, it fails if there
+// are nodes to spread to but none of them takes the data, or if any of them
+// refuses it as not authorized
+func (k *kademliaImpl) TransferDataToCloserNodes(ctx context.Context, key KeyType, val ValueType, enableLookup bool) error {
+	_, b := k.router.FindBucket(k.cfg.hash(key))
 	var contacts []ContWithDist
-	if enableLookup && time.Now().After(b.timeStamp.Add(RefreshInterval)) {
-		_, contacts, _, _ = k.Lookup(key, hash(key), k.proto.rpcFindNode)
+	if enableLookup && k.cfg.Clock.Now().After(b.timeStamp.Add(k.cfg.RefreshInterval)) {
+		_, contacts, _, _ = k.Lookup(ctx, key, k.cfg.hash(key), k.proto.rpcFindNode)
 	} else {
-		contacts = k.router.GetClosestContacts(hash(key), K)
+		contacts = k.router.GetClosestContacts(k.cfg.hash(key), k.cfg.K)
+	}
+	var (
+		ch      = make(chan bool, k.cfg.Alpha)
+		wg      sync.WaitGroup
+		stored  int32
+		refused int32
+	)
+	count := func(err error) {
+		if err == nil {
+			atomic.AddInt32(&stored, 1)
+		} else if errors.Is(err, dht.ErrUnauthorized) {
+			atomic.AddInt32(&refused, 1)
+		}
 	}
-	ch := make(chan bool, Alpha)
 	for _, v := range contacts {
+		if k.cfg.Manual {
+			count(k.proto.rpcStore(ctx, v.Cont, key, val, false, 0))
+			continue
+		}
+		wg.Add(1)
 		go func(c Contact) {
+			defer wg.Done()
 			ch <- true
-			k.proto.rpcStore(c, key, val, false, 0)
+			count(k.proto.rpcStore(ctx, c, key, val, false, 0))
 			<-ch
 		}(v.Cont)
 	}
+	wg.Wait()
+	switch {
+	case stored > 0 || len(contacts) == 0:
+	case refused > 0:
+		// a contact refusing alone does not fail the put
+		return dht.ErrUnauthorized
+	default:
+		return dht.ErrReplicaWrite
+	}
+	return nil
 }
 
 func (b *bucketList) RefreshBucket() {
-	ch := make(chan bool, Alpha)
+	refreshRounds.With(b.host.Addr).Inc()
+	var refreshes []func()
 	b.ForEachBucket(func(kb *kBucket) {
-		if time.Now().After(kb.timeStamp.Add(RefreshInterval)) {
+		if b.cfg.Clock.Now().After(kb.timeStamp.Add(b.cfg.RefreshInterval)) {
 			kb.Touch()
-			go func() {
-				ch <- true
-				randID := RandomID(kb.bucketRange)
-				temp := kb.CopyContact()
+			// drawn here, the generator is not safe for concurrent use
+			randID := RandomID(kb.bucketRange, b.rand)
+			temp := kb.CopyContact()
+			refreshes = append(refreshes, func() {
 				for _, c := range temp {
-					ret, _ := b.proto.rpcFindNode(c, NIL, randID)
+					ret, _ := b.proto.rpcFindNode(context.Background(), c, NIL, randID)
 					for _, v := range ret.Cont {
 						b.AddContact(v.Cont)
 					}
 				}
-				<-ch
-			}()
+			})
 		}
 	})
+	// run once the buckets are unlocked, AddContact may split them
+	ch := make(chan bool, b.cfg.Alpha)
+	for _, refresh := range refreshes {
+		if b.cfg.Manual {
+			refresh()
+			continue
+		}
+		go func(refresh func()) {
+			ch <- true
+			refresh()
+			<-ch
+		}(refresh)
+	}
 }
 
-func (s *storage) RepublishData(republishFunc func(KeyType, ValueType)) {
-	now := time.Now()
+func (s *storage) RepublishData(interval time.Duration, republishFunc func(KeyType, ValueType)) {
+	now := s.clock.Now()
 	s.lock.RLock()
 	tmp := []KeyType{}
-	for k, v := range s.store {
-		if now.After(v.repubTimeStamp.Add(RepublishInterval)) {
-			republishFunc(k, v.value)
-			tmp = append(tmp, k)
+	for k, v := range s.meta {
+		if now.After(v.repubTimeStamp.Add(interval)) {
+			if val, ok := s.engine.Get(k); ok {
+				republishFunc(k, val)
+				tmp = append(tmp, k)
+			}
 		}
 	}
 	s.lock.RUnlock()
@@ -86,10 +150,10 @@ func (s *storage) RepublishData(republishFunc func(KeyType, ValueType)) {
 }
 
 func (s *storage) ExpireData() {
-	now := time.Now()
+	now := s.clock.Now()
 	s.lock.RLock()
 	tmp := []KeyType{}
-	for k, v := range s.store {
+	for k, v := range s.meta {
 		if now.After(v.repubTimeStamp.Add(v.expireDura)) {
 			tmp = append(tmp, k)
 		}
@@ -101,72 +165,77 @@ func (s *storage) ExpireData() {
 }
 
 func (k *kademliaImpl) maintain() {
+	if k.cfg.Manual {
+		// left to the caller of Maintain
+		return
+	}
 	go func() {
-		ticker := time.NewTicker(RefreshInterval)
+		ticker := k.cfg.Clock.NewTicker(k.cfg.RefreshInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-k.quitSignal:
 				return
-			case <-ticker.C:
+			case <-ticker.Chan():
 				k.router.RefreshBucket()
+				k.detector.Prune(k.cfg.ExpireTime, nil)
 			}
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(RepublishInterval)
+		ticker := k.cfg.Clock.NewTicker(k.cfg.RepublishInterval)
 		defer ticker.Stop()
 		repubFunc := func(kt KeyType, vt ValueType) {
-			k.TransferDataToCloserNodes(kt, vt, false)
+			k.TransferDataToCloserNodes(context.Background(), kt, vt, false)
 		}
 		for {
 			select {
 			case <-k.quitSignal:
 				return
-			case <-ticker.C:
-				k.origin.RepublishData(repubFunc)
+			case <-ticker.Chan():
+				k.origin.RepublishData(k.cfg.RepublishInterval, repubFunc)
 			}
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(RepublishInterval)
+		ticker := k.cfg.Clock.NewTicker(k.cfg.RepublishInterval)
 		defer ticker.Stop()
 		repubFunc := func(kt KeyType, vt ValueType) {
-			k.TransferDataToCloserNodes(kt, vt, true)
+			k.TransferDataToCloserNodes(context.Background(), kt, vt, true)
 		}
 		for {
 			select {
 			case <-k.quitSignal:
 				return
-			case <-ticker.C:
-				k.replicate.RepublishData(repubFunc)
+			case <-ticker.Chan():
+				k.replicate.RepublishData(k.cfg.RepublishInterval, repubFunc)
 			}
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(ExpireTime)
+		ticker := k.cfg.Clock.NewTicker(k.cfg.ExpireTime)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-k.quitSignal:
 				return
-			case <-ticker.C:
+			case <-ticker.Chan():
 				k.replicate.ExpireData()
 			}
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(ExpireTime)
+		ticker := k.cfg.Clock.NewTicker(k.cfg.ExpireTime)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-k.quitSignal:
 				return
-			case <-ticker.C:
+			case <-ticker.Chan():
 				k.cache.ExpireData()
 			}
 		}
@@ -180,3 +249,33 @@ func (k *kademliaImpl) maintain() {
 	// 	}
 	// }()
 }
+
+// run the maintenance tasks due by the clock of the node, one after
+// another in the calling goroutine, in manual mode
+func (k *kademliaImpl) round() {
+	if !k.online {
+		return
+	}
+	k.dueLock.Lock()
+	defer k.dueLock.Unlock()
+	now := k.cfg.Clock.Now()
+	if !now.Before(k.refreshDue) {
+		k.router.RefreshBucket()
+		k.detector.Prune(k.cfg.ExpireTime, nil)
+		k.refreshDue = now.Add(k.cfg.RefreshInterval)
+	}
+	if !now.Before(k.republishDue) {
+		k.origin.RepublishData(k.cfg.RepublishInterval, func(kt KeyType, vt ValueType) {
+			k.TransferDataToCloserNodes(context.Background(), kt, vt, false)
+		})
+		k.replicate.RepublishData(k.cfg.RepublishInterval, func(kt KeyType, vt ValueType) {
+			k.TransferDataToCloserNodes(context.Background(), kt, vt, true)
+		})
+		k.republishDue = now.Add(k.cfg.RepublishInterval)
+	}
+	if !now.Before(k.expireDue) {
+		k.replicate.ExpireData()
+		k.cache.ExpireData()
+		k.expireDue = now.Add(k.cfg.ExpireTime)
+	}
+}
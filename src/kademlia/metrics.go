@@ -0,0 +1,39 @@
+package kademlia
+
+import "DHT-2022/src/metrics"
+
+var (
+	lookupHops = metrics.Default.NewHistogramVec("kademlia_lookup_hops",
+		"Contacts answering a lookup.", metrics.HopBuckets, "node")
+	lookupSeconds = metrics.Default.NewHistogramVec("kademlia_lookup_seconds",
+		"Time taken by lookups, by outcome.", metrics.LatencyBuckets, "node", "outcome")
+	bucketSplits = metrics.Default.NewCounterVec("kademlia_bucket_splits_total",
+		"K-buckets split to make room for a contact.", "node")
+	bucketEvictions = metrics.Default.NewCounterVec("kademlia_bucket_evictions_total",
+		"Contacts evicted from full k-buckets for not answering.", "node")
+	refreshRounds = metrics.Default.NewCounterVec("kademlia_refresh_rounds_total",
+		"Bucket refresh rounds run.", "node")
+	identityFailures = metrics.Default.NewCounterVec("kademlia_identity_failures_total",
+		"Contacts dropped for not proving the identity they claim.", "node")
+	rejectedWrites = metrics.Default.NewCounterVec("kademlia_rejected_writes_total",
+		"Stores refused for their signatures, by reason.", "node", "reason")
+	storedKeys = metrics.Default.NewGaugeVec("kademlia_stored_keys",
+		"Keys held by a node, by store.", "node", "store")
+)
+
+// report the sizes of the storages of the node when scraped, the storages
+// are looked up then since SetStorage replaces them
+func (k *kademliaImpl) exportStores() {
+	for name, get := range map[string]func() *storage{
+		"origin":    func() *storage { return k.origin },
+		"replicate": func() *storage { return k.replicate },
+		"cache":     func() *storage { return k.cache },
+	} {
+		get := get
+		storedKeys.With(k.addr, name).Func(func() float64 {
+			k.storeLock.RLock()
+			defer k.storeLock.RUnlock()
+			return float64(get().Len())
+		})
+	}
+}
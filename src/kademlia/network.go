@@ -1,6 +1,11 @@
 package kademlia
 
 import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/detector"
+	"DHT-2022/src/dht"
+	"DHT-2022/src/network"
+	"context"
 	"errors"
 	"net"
 	"net/rpc"
@@ -14,20 +19,56 @@ type networkNode struct {
 	addr       Address
 	server     *rpc.Server
 	listener   net.Listener
+	conns      network.ConnSet
+	pool       *network.Pool
+	detector   *detector.Detector
 	online     bool
 	quitSignal chan bool
+
+	logs        *log.Logger
+	transport   network.Transport
+	pingTimeOut time.Duration
+	clock       clock.Clock
+}
+
+func newPool(local Address, cfg Config) *network.Pool {
+	return network.NewPool(network.PoolConfig{
+		DialAttempt: DialAttempt,
+		DialTimeout: cfg.DialTimeout,
+		MaxIdle:     PoolMaxIdle,
+		MaxOpen:     PoolMaxOpen,
+		IdleTimeout: PoolIdleTimeOut,
+		Clock:       cfg.Clock,
+		Local:       local,
+		Faults:      cfg.Faults,
+		Auth:        network.NewAuth(cfg.ClusterKey, local, cfg.Clock),
+	}, cfg.Transport.Dial)
+}
+
+func newDetector(refreshInterval time.Duration, clk clock.Clock) *detector.Detector {
+	return detector.New(detector.Config{
+		Threshold:     SuspectThreshold,
+		Window:        SuspectWindow,
+		MinStdDev:     refreshInterval / 4,
+		FirstInterval: refreshInterval,
+		FailureTTL:    FailureTrustTime,
+		Clock:         clk,
+	})
 }
 
 func (n *networkNode) launch() error {
 	n.server = rpc.NewServer()
 	err := n.server.RegisterName("KademliaService", n.proto)
+	if err == nil {
+		err = network.RegisterHealth(n.server)
+	}
 	if err != nil {
-		errLogger(n.addr, err).Error("launch failed while register")
+		n.errLogger(err).Error("launch failed while register")
 		return err
 	}
-	n.listener, err = net.Listen("tcp", n.addr)
+	n.listener, err = n.transport.Listen(n.addr)
 	if err != nil {
-		errLogger(n.addr, err).Error("launch failed while listen")
+		n.errLogger(err).Error("launch failed while listen")
 		return err
 	}
 	go n.connect()
@@ -43,74 +84,45 @@ func (n *networkNode) connect() error {
 		conn, err = n.listener.Accept()
 		select {
 		case <-n.quitSignal:
-			logger(n.addr).Info("server go offline")
+			n.logger().Info("server go offline")
 			return nil
 		default:
 			if err != nil {
-				errLogger(n.addr, err).Error("connect failed while accept")
+				n.errLogger(err).Error("connect failed while accept")
 				return err
 			} else {
-				logger(n.addr).Info("connect succeeded")
-				go n.server.ServeConn(conn)
+				n.logger().Info("connect succeeded")
+				go n.conns.Serve(n.server, conn)
 			}
 		}
 	}
 }
 
-func (n *networkNode) dial(address Address) (*rpc.Client, error) {
-	if address == NIL {
-		return nil, errors.New("invalid address")
-	}
-	var (
-		client *rpc.Client
-		err    error
-	)
-	for i := 1; i <= DialAttempt; i++ {
-		dialMsg := make(chan error, 1)
-		go func() {
-			client, err = rpc.Dial("tcp", address)
-			dialMsg <- err
-		}()
-		select {
-		case <-dialMsg:
-			if err != nil {
-				errLogger(n.addr, err).WithField("target", address).Info("dail failed")
-				return nil, err
-			} else {
-				logger(n.addr).WithField("target", address).Info("dail succeeded")
-				return client, err
-			}
-		case <-time.After(DialTimeOut):
-			logger(n.addr).WithField("target", address).Tracef("dail time out in attempt%d", i)
-		}
-	}
-	logger(n.addr).WithField("target", address).Info("dail time out")
-	return nil, errors.New("dial time out")
+func (n *networkNode) call(address Address, service string, method string, request interface{}, reply interface{}) error {
+	return n.callCtx(context.Background(), address, service, method, request, reply)
 }
 
-func (n *networkNode) call(address Address, service string, method string, request interface{}, reply interface{}) error {
-	client, err := n.dial(address)
-	if err != nil {
-		errLogger(n.addr, err).WithField("target", address).Error("rpc failed while dailing")
-		return err
+// remote call over a pooled connection that gives up as soon as CTX is
+// done, the connection is dropped then so that the call is aborted
+func (n *networkNode) callCtx(ctx context.Context, address Address, service string, method string, request interface{}, reply interface{}) error {
+	if address == NIL {
+		return errors.New("invalid address")
 	}
-	var rpcLogger = logger(n.addr).WithFields(log.Fields{
+	var rpcLogger = n.logger().WithFields(log.Fields{
 		"target":  address,
 		"service": service,
 		"method":  method,
 	})
-	logger(n.addr).WithField("request", request).
+	n.logger().WithField("request", request).
 		Tracef("remote call sending request")
-	err = client.Call(service+"."+method, request, reply)
+	err := n.pool.Call(ctx, address, service+"."+method, request, reply)
+	n.observe(address, err)
+	err = dht.FromRPC(err)
 	if err != nil {
 		rpcLogger.WithError(err).Error("rpc failed while calling")
 		return err
 	}
 	rpcLogger.WithField("reply", reply).Tracef("remote call got reply")
-	if err := client.Close(); err != nil {
-		rpcLogger.WithError(err).Error("rpc failed while closing")
-		return err
-	}
 	return nil
 }
 
@@ -118,45 +130,44 @@ func (n *networkNode) ping(address Address) bool {
 	if address == NIL {
 		return false
 	}
-	var (
-		client     *rpc.Client
-		err        error
-		pingLogger = logger(n.addr).WithField("target", address)
-	)
+	var pingLogger = n.logger().WithField("target", address)
 	for i := 1; i <= PingAttempt; i++ {
-		pingMsg := make(chan error, 1)
-		go func() {
-			client, err = rpc.Dial("tcp", address)
-			if err == nil {
-				pingLogger.Tracef("ping succeded in attempt%d", i)
-				defer client.Close()
-			} else {
-				pingLogger.Tracef("ping failed in atttempt%d", i)
-			}
-			pingMsg <- err
-		}()
-		select {
-		case <-pingMsg:
-			if err != nil {
-				pingLogger.Info("ping failed")
-				return false
-			} else {
-				pingLogger.Info("ping succeeded")
-				return true
-			}
-		case <-time.After(PingTimeOut):
-			pingLogger.Tracef("ping time out in attempt%d", i)
+		ctx, cancel := n.clock.WithTimeout(context.Background(), n.pingTimeOut)
+		err := n.pool.Ping(ctx, address)
+		cancel()
+		n.observe(address, err)
+		if err == nil {
+			pingLogger.Info("ping succeeded")
+			return true
 		}
+		if !errors.Is(err, dht.ErrTimeout) {
+			pingLogger.WithError(err).Info("ping failed")
+			return false
+		}
+		pingLogger.Tracef("ping time out in attempt%d", i)
 	}
 	pingLogger.Info("ping time out")
+	n.detector.Failure(address)
 	return false
 }
 
+// feed the outcome of a call to ADDRESS to the failure detector
+func (n *networkNode) observe(address Address, err error) {
+	alive, failed := network.Outcome(err)
+	if alive {
+		n.detector.Success(address)
+	} else if failed {
+		n.detector.Failure(address)
+	}
+}
+
 func (n *networkNode) shutdown() {
 	n.online = false
 	close(n.quitSignal)
 	err := n.listener.Close()
 	if err != nil {
-		errLogger(n.addr, err).Error("shutdown failed")
+		n.errLogger(err).Error("shutdown failed")
 	}
+	// the pooled connections of the peers outlive the listener
+	n.conns.CloseAll()
 }
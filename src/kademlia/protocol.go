@@ -1,6 +1,14 @@
 package kademlia
 
-import "time"
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// NIL stands for a missing value, it can not be stored
+var errEmptyValue = errors.New("empty value")
 
 type protocol struct {
 	node *kademliaImpl
@@ -18,12 +26,31 @@ type PingReply struct {
 }
 
 func (p *protocol) rpcPing(c Contact) bool {
+	_, err := p.rpcContact(c.Addr)
+	return err == nil
+}
+
+// the contact of the node at ADDR as it replies to a ping
+func (p *protocol) rpcContact(addr Address) (Contact, error) {
 	request := PingRequst{
 		RpcHeader: RpcHeader{Sender: p.node.router.host},
 	}
 	reply := new(PingReply)
-	err := p.node.call(c.Addr, "KademliaService", "HandlePing", request, reply)
-	return err != nil
+	err := p.node.call(addr, "KademliaService", "HandlePing", request, reply)
+	return reply.Sender, err
+}
+
+// whether the contact is taken as online, a contact suspected only for
+// being silent for long is pinged to make sure
+func (p *protocol) alive(c Contact) bool {
+	phi, known := p.node.detector.Phi(c.Addr)
+	if known && phi < SuspectThreshold {
+		return true
+	}
+	if known && math.IsInf(phi, 1) {
+		return false
+	}
+	return p.rpcPing(c)
 }
 
 func (p *protocol) HandlePing(request PingRequst, reply *PingReply) error {
@@ -31,6 +58,7 @@ func (p *protocol) HandlePing(request PingRequst, reply *PingReply) error {
 		RpcHeader: RpcHeader{Sender: p.node.router.host},
 	}
 	p.node.TransferDataToNewNodes(request.Sender)
+	p.node.detector.Success(request.Sender.Addr)
 	p.node.router.AddContact(request.Sender)
 	return nil
 }
@@ -43,13 +71,13 @@ type FindNodeReply struct {
 	LookupRet
 }
 
-func (p *protocol) rpcFindNode(c Contact, _ KeyType, id Identifer) (LookupRet, error) {
+func (p *protocol) rpcFindNode(ctx context.Context, c Contact, _ KeyType, id Identifer) (LookupRet, error) {
 	request := FindNodeRequest{
 		RpcHeader: RpcHeader{Sender: p.node.router.host},
 		ID:        id,
 	}
 	reply := new(FindNodeReply)
-	err := p.node.call(c.Addr, "KademliaService", "HandleFindNode", request, reply)
+	err := p.node.callCtx(ctx, c.Addr, "KademliaService", "HandleFindNode", request, reply)
 	return reply.LookupRet, err
 }
 
@@ -67,13 +95,13 @@ type FindValueReply struct {
 	LookupRet
 }
 
-func (p *protocol) rpcFindValue(c Contact, key string, _ Identifer) (LookupRet, error) {
+func (p *protocol) rpcFindValue(ctx context.Context, c Contact, key string, _ Identifer) (LookupRet, error) {
 	request := FindValueRequest{
 		RpcHeader: RpcHeader{Sender: p.node.router.host},
 		Key:       key,
 	}
 	reply := new(FindValueReply)
-	err := p.node.call(c.Addr, "KademliaService", "HandleFindValue", request, reply)
+	err := p.node.callCtx(ctx, c.Addr, "KademliaService", "HandleFindValue", request, reply)
 	return reply.LookupRet, err
 }
 
@@ -92,9 +120,9 @@ type StoreRequest struct {
 }
 type StoreReply struct{}
 
-func (p *protocol) rpcStore(c Contact, key KeyType, value ValueType, cached bool, expire time.Duration) error {
+func (p *protocol) rpcStore(ctx context.Context, c Contact, key KeyType, value ValueType, cached bool, expire time.Duration) error {
 	if value == NIL {
-		panic("rpc store invalid value")
+		return errEmptyValue
 	}
 	request := StoreRequest{
 		RpcHeader:  RpcHeader{Sender: p.node.router.host},
@@ -104,16 +132,15 @@ func (p *protocol) rpcStore(c Contact, key KeyType, value ValueType, cached bool
 		ExpireTime: expire,
 	}
 	reply := new(StoreReply)
-	err := p.node.call(c.Addr, "KademliaService", "HandleStore", request, reply)
+	err := p.node.callCtx(ctx, c.Addr, "KademliaService", "HandleStore", request, reply)
 	return err
 }
 
 func (p *protocol) HandleStore(request StoreRequest, reply *StoreReply) error {
-	p.node.primitiveStore(
+	return p.node.primitiveStore(
 		request.Sender,
 		request.Key, request.Val,
 		request.Cached,
 		request.ExpireTime,
 	)
-	return nil
 }
@@ -1,68 +1,115 @@
 package kademlia
 
 import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/store"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
-type storeData struct {
-	value          ValueType
+type storeMeta struct {
 	repubTimeStamp time.Time
 	expireDura     time.Duration
 }
 
+// values are kept by the storage engine, timestamps for republishing
+// and expiration are kept in memory
 type storage struct {
-	lock  sync.RWMutex
-	store map[KeyType]storeData
+	lock   sync.RWMutex
+	engine store.Engine
+	meta   map[KeyType]storeMeta
+	clock  clock.Clock
+	logger *log.Entry
 }
 
-func NewStorage() *storage {
+// NewStorage wraps ENGINE, keys it already holds expire after EXPIRE by
+// the time of CLK. Failures of the engine are logged to LOGGER
+func NewStorage(engine store.Engine, expire time.Duration, clk clock.Clock, logger *log.Entry) *storage {
 	ret := new(storage)
-	ret.store = make(map[KeyType]storeData)
+	ret.engine = engine
+	ret.clock = clk
+	ret.logger = logger
+	ret.meta = make(map[KeyType]storeMeta)
+	// data reloaded by a durable engine is taken as just republished
+	now := clk.Now()
+	engine.ForEach(func(k KeyType, _ ValueType) bool {
+		ret.meta[k] = storeMeta{now, expire}
+		return true
+	})
 	return ret
 }
 
 func (s *storage) Get(key KeyType) (ValueType, bool) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	if data, ok := s.store[key]; ok {
-		// if data.value == NIL {
-		// 	panic("invalid data")
-		// }
-		return data.value, true
-	} else {
-		return NIL, false
-	}
+	return s.engine.Get(key)
 }
 
 func (s *storage) Put(key KeyType, val ValueType, expire time.Duration) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.put(key, val, expire)
+}
+
+// PutChecked puts VAL under KEY like Put if CHECK accepts it in place of
+// the value stored, empty if none
+func (s *storage) PutChecked(key KeyType, val ValueType, expire time.Duration, check func(old ValueType) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, _ := s.engine.Get(key)
+	if err := check(old); err != nil {
+		return err
+	}
+	s.put(key, val, expire)
+	return nil
+}
+
+func (s *storage) put(key KeyType, val ValueType, expire time.Duration) {
 	// if val == NIL {
 	// 	panic("invalid data")
 	// }
-	s.store[key] = storeData{val, time.Now(), expire}
+	if err := s.engine.Put(key, val); err != nil {
+		s.logger.WithError(err).WithField("key", key).Error("storage put failed")
+		return
+	}
+	s.meta[key] = storeMeta{s.clock.Now(), expire}
 }
 
 func (s *storage) Remove(key KeyType) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	delete(s.store, key)
+	s.engine.Delete(key)
+	delete(s.meta, key)
 }
 
 func (s *storage) Touch(key KeyType) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if v, ok := s.store[key]; ok {
-		v.repubTimeStamp = time.Now()
-		s.store[key] = v
+	if v, ok := s.meta[key]; ok {
+		v.repubTimeStamp = s.clock.Now()
+		s.meta[key] = v
 	}
 }
 
 func (s *storage) ForEachKeyValue(foo func(KeyType, ValueType)) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	for k, v := range s.store {
-		foo(k, v.value)
-	}
+	s.engine.ForEach(func(k KeyType, v ValueType) bool {
+		foo(k, v)
+		return true
+	})
+}
+
+func (s *storage) Len() int {
+	return s.engine.Len()
+}
+
+func (s *storage) Size() int64 {
+	return s.engine.Size()
+}
+
+func (s *storage) Close() error {
+	return s.engine.Close()
 }
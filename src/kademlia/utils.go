@@ -1,16 +1,18 @@
 package kademlia
 
 import (
-	"crypto/sha1"
-	"math/big"
+	"DHT-2022/src/ident"
 	"math/rand"
 	"time"
 )
 
+// K, B, Alpha and the intervals and timeouts below are the defaults of
+// Config
 const (
 	NIL = ""
 
-	M     = 160
+	// widest identifiers, digests are cut to it
+	M     = ident.Bits
 	K     = 20
 	B     = 5
 	Alpha = 3
@@ -21,6 +23,16 @@ const (
 	PingTimeOut   = 500 * time.Millisecond
 	LookupTimeOut = 500 * time.Millisecond
 
+	PoolMaxIdle     = 2
+	PoolMaxOpen     = 128
+	PoolIdleTimeOut = 30 * time.Second
+
+	// contacts are mostly heard from by the requests they send, suspicion
+	// by silence is slow to rise and confirmed by a ping
+	SuspectThreshold = 8.0
+	SuspectWindow    = 100
+	FailureTrustTime = 10 * time.Second
+
 	ExpireTime        = 40 * time.Second
 	RefreshInterval   = 30 * time.Second
 	RepublishInterval = 30 * time.Second
@@ -28,7 +40,7 @@ const (
 
 type (
 	Address   = string
-	Identifer = *big.Int
+	Identifer = ident.ID
 	KeyType   = string
 	ValueType = string
 )
@@ -75,50 +87,48 @@ func minInSlice(s []ContWithDist, skip Contact) ContWithDist {
 	return ret
 }
 
-func hash(key string) Identifer {
-	hasher := sha1.New()
-	hasher.Write([]byte(key))
-	return new(big.Int).SetBytes(hasher.Sum(nil))
-}
-
-func NewID(x int64) Identifer {
-	return big.NewInt(x)
-}
-
-func RandomID(interval IDRange) Identifer {
-	ret := new(big.Int).Rand(
-		IDGenerator,
-		interval.length(),
-	)
-	return ret.Add(ret, interval.low)
-}
-
-func IDpow2(x int) Identifer {
-	return new(big.Int).Exp(NewID(2), big.NewInt(int64(x)), nil)
+// a random identifier of INTERVAL
+func RandomID(interval IDRange, gen *rand.Rand) Identifer {
+	var ret Identifer
+	gen.Read(ret[:])
+	return ret.Trunc(interval.bits).Xor(interval.low)
 }
 
 func Distance(x, y Identifer) Identifer {
-	return new(big.Int).Xor(x, y)
+	return x.Xor(y)
 }
 
-func SharedPrefixLen(x, y Identifer) int {
-	return M - new(big.Int).Xor(x, y).BitLen()
+// leading bits X and Y share as identifiers of width M
+func SharedPrefixLen(x, y Identifer, m int) int {
+	return m - x.Xor(y).BitLen()
 }
 
+// IDRange is the range of the 2^BITS identifiers from LOW, which has its
+// last BITS bits clear, as the buckets are split in halves
 type IDRange struct {
-	low, high Identifer
+	low  Identifer
+	bits int
 }
 
 func (r *IDRange) contain(id Identifer) bool {
-	return id.Cmp(r.low) >= 0 && id.Cmp(r.high) < 0
+	return id.Xor(r.low).BitLen() <= r.bits
+}
+
+// whether the whole range is below ID
+func (r *IDRange) below(id Identifer) bool {
+	return r.low.Cmp(id) < 0 && !r.contain(id)
 }
 
-func (r *IDRange) midpoint() Identifer {
-	return new(big.Int).Div(new(big.Int).Add(r.low, r.high), NewID(2))
+// the lower and upper halves of the range
+func (r *IDRange) split() (IDRange, IDRange) {
+	return IDRange{low: r.low, bits: r.bits - 1},
+		IDRange{low: r.low.SetBit(r.bits - 1), bits: r.bits - 1}
 }
 
-func (r *IDRange) length() Identifer {
-	return new(big.Int).Sub(r.high, r.low)
+// the first identifier past the range, 0 past the last range as the
+// identifiers wrap around
+func (r *IDRange) high() Identifer {
+	return r.low.AddPow2(r.bits)
 }
 
 type ContWithDist struct {
@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config tells a node where and how much to log. An injected Logger is used
+// as is, otherwise a logger of its own is made for the node, writing to
+// File, or to Output, or to stderr. The zero value logs warnings and worse
+// to stderr
+type Config struct {
+	Logger *log.Logger
+	// one of the logrus levels, "warn" by default
+	Level  string
+	Output io.Writer
+	File   string
+	// the file is rotated once it grows past MaxSize bytes, keeping
+	// MaxBackups old files as FILE.1, FILE.2 and so on, 0 for no rotation
+	MaxSize    int64
+	MaxBackups int
+}
+
+const (
+	defaultLevel      = log.WarnLevel
+	defaultMaxBackups = 3
+)
+
+func (c Config) Validate() error {
+	if c.Level != "" {
+		if _, err := log.ParseLevel(c.Level); err != nil {
+			return fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("invalid config: negative log rotation limit")
+	}
+	return nil
+}
+
+// New makes the logger described by CFG
+func New(cfg Config) (*log.Logger, error) {
+	if cfg.Logger != nil {
+		return cfg.Logger, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ret := log.New()
+	ret.SetLevel(defaultLevel)
+	if cfg.Level != "" {
+		level, _ := log.ParseLevel(cfg.Level)
+		ret.SetLevel(level)
+	}
+	switch {
+	case cfg.File != "":
+		file, err := Open(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		ret.SetOutput(file)
+	case cfg.Output != nil:
+		ret.SetOutput(cfg.Output)
+	}
+	return ret, nil
+}
+
+// File is a log file that rotates itself once grown too large, it is safe
+// for concurrent use
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+var (
+	filesLock sync.Mutex
+	files     = make(map[string]*File)
+)
+
+// Open opens the log file at PATH for appending. The nodes logging to the
+// same path share one File, kept open for the life of the process, the
+// rotation limits it was first opened with are kept
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	filesLock.Lock()
+	defer filesLock.Unlock()
+	if f, ok := files[path]; ok {
+		return f, nil
+	}
+	if maxSize > 0 && maxBackups == 0 {
+		maxBackups = defaultMaxBackups
+	}
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	files[path] = f
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// shift FILE.i to FILE.i+1, the oldest one is dropped, and start afresh
+func (f *File) rotate() error {
+	f.file.Close()
+	os.Remove(f.backup(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(f.backup(i), f.backup(i+1))
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return f.open()
+}
+
+func (f *File) backup(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
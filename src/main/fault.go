@@ -0,0 +1,141 @@
+package main
+
+import (
+	"DHT-2022/src/network"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	faultNodeSize           int     = 30
+	faultPutSize            int     = 300
+	faultMaxFailRate        float64 = 0.05
+	faultAfterRunSleepTime          = 200 * time.Millisecond
+	faultJoinSleepTime              = 500 * time.Millisecond
+	faultAfterJoinSleepTime         = 10 * time.Second
+	faultPartitionTime              = 10 * time.Second
+	faultAfterHealSleepTime         = 20 * time.Second
+)
+
+var faultLink = network.LinkFaults{
+	Latency: 2 * time.Millisecond,
+	Jitter:  8 * time.Millisecond,
+	Loss:    0.002,
+}
+
+// faults injected into the calls of the nodes made by NewNode, nil for
+// none
+var faults *network.Faults
+
+// the network is put under latency and loss, then split in two halves
+// that later heal. Gets made during the partition are only reported, as
+// each half holds part of the keys, the ones made once the halves have
+// merged back into one ring are counted. Keys, values and the nodes asked
+// are drawn from SEED
+func faultTest(seed int64) (bool, int, int) {
+	_, _ = yellow.Printf("Start Fault Injection Test (seed %d)\n", seed)
+
+	faultFailedCnt, faultTotalCnt, panicked := 0, 0, false
+	gen := rand.New(rand.NewSource(seed))
+
+	faults = network.NewFaults(seed)
+	defer func() { faults = nil }()
+	faults.SetDefault(faultLink)
+
+	nodes := new([faultNodeSize + 1]dhtNode)
+	nodeAddresses := new([faultNodeSize + 1]string)
+	kvMap := make(map[string]string)
+
+	wg = new(sync.WaitGroup)
+	for i := 0; i <= faultNodeSize; i++ {
+		nodes[i] = NewNode(firstPort + i)
+		nodeAddresses[i] = portToAddr(localAddress, firstPort+i)
+
+		wg.Add(1)
+		go nodes[i].Run()
+	}
+	time.Sleep(faultAfterRunSleepTime)
+
+	joinInfo := testInfo{
+		msg:       "Fault join",
+		failedCnt: 0,
+		totalCnt:  0,
+	}
+	nodes[0].Create()
+	for i := 1; i <= faultNodeSize; i++ {
+		if !nodes[i].Join(nodeAddresses[gen.Intn(i)]) {
+			joinInfo.fail()
+		} else {
+			joinInfo.success()
+		}
+		time.Sleep(faultJoinSleepTime)
+	}
+	joinInfo.finish(&faultFailedCnt, &faultTotalCnt)
+	time.Sleep(faultAfterJoinSleepTime)
+
+	putInfo := testInfo{
+		msg:       "Fault put",
+		failedCnt: 0,
+		totalCnt:  0,
+	}
+	for i := 0; i < faultPutSize; i++ {
+		key := randStringFrom(gen.Intn, lengthOfKeyValue)
+		value := randStringFrom(gen.Intn, lengthOfKeyValue)
+		// only the keys stored are looked for later
+		if !nodes[gen.Intn(faultNodeSize+1)].Put(key, value) {
+			putInfo.fail()
+		} else {
+			kvMap[key] = value
+			putInfo.success()
+		}
+	}
+	putInfo.finish(&faultFailedCnt, &faultTotalCnt)
+
+	/* Split the nodes in two halves. */
+	half := (faultNodeSize + 1) / 2
+	faults.Partition(nodeAddresses[:half], nodeAddresses[half:])
+	_, _ = cyan.Printf("Partitioned into %d and %d nodes\n", half, faultNodeSize+1-half)
+	time.Sleep(faultPartitionTime)
+	// in a fixed order, for the nodes asked to be reproducible
+	keys := make([]string, 0, len(kvMap))
+	for key := range kvMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for side, group := range [][]dhtNode{nodes[:half], nodes[half:]} {
+		got := 0
+		for _, key := range keys {
+			if ok, res := group[gen.Intn(len(group))].Get(key); ok && res == kvMap[key] {
+				got++
+			}
+		}
+		_, _ = cyan.Printf("Side %d got %d/%d keys during the partition\n", side, got, len(kvMap))
+	}
+
+	faults.Heal()
+	_, _ = cyan.Printf("Partition healed\n")
+	time.Sleep(faultAfterHealSleepTime)
+
+	getInfo := testInfo{
+		msg:       "Get after heal",
+		failedCnt: 0,
+		totalCnt:  0,
+	}
+	for _, key := range keys {
+		if ok, res := nodes[gen.Intn(faultNodeSize+1)].Get(key); ok && res == kvMap[key] {
+			getInfo.success()
+		} else {
+			getInfo.fail()
+		}
+	}
+	getInfo.finish(&faultFailedCnt, &faultTotalCnt)
+
+	faults.Reset()
+	for i := 0; i <= faultNodeSize; i++ {
+		nodes[i].Quit()
+	}
+
+	return panicked, faultFailedCnt, faultTotalCnt
+}
@@ -1,41 +1,131 @@
 package main
 
 import (
+	"DHT-2022/src/chord"
+	"DHT-2022/src/ident"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/metrics"
+	"DHT-2022/src/network"
 	"flag"
 	"math/rand"
 	"os"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	help     bool
 	testName string
+	seed     int64
+
+	metricsAddr string
+	logFile     string
+	logLevel    string
+	useTLS      bool
+	clusterKey  string
+	useIdentity bool
+	idWidth     int
+	idHash      ident.Hash
+)
+
+// shared by the nodes under test
+var (
+	nodeLogger *log.Logger
+	// TLS between the nodes made by NewNode, nil for plaintext
+	tlsConfig *network.TLSConfig
 )
 
 func init() {
 	flag.BoolVar(&help, "help", false, "help")
-	flag.StringVar(&testName, "test", "", "which test(s) do you want to run: basic/advance/all")
+	flag.StringVar(&testName, "test", "", "which test(s) do you want to run: basic/advance/all/sim/fault")
+	flag.StringVar(&metricsAddr, "metrics", "", "address to serve Prometheus metrics at, none by default")
+	flag.StringVar(&logFile, "log-file", "debug.log", "file the nodes log to")
+	flag.StringVar(&logLevel, "log-level", "warn", "level the nodes log at")
+	flag.BoolVar(&useTLS, "tls", false, "secure the traffic between the nodes with mutual TLS, using a CA made for the run")
+	flag.StringVar(&clusterKey, "cluster-key", "", "key the nodes sign their requests with, unsigned if empty")
+	flag.BoolVar(&useIdentity, "identity", false, "give each node an Ed25519 identity its ID is derived from")
+	flag.IntVar(&idWidth, "m", chord.M, "width of the identifiers of the nodes")
+	flag.Var(&idHash, "hash", "hash the identifiers are taken from: sha1, sha256 or sha3-256")
+	flag.Int64Var(&seed, "seed", 0, "seed of the simulated and fault tests, 0 for a random one")
 
 	flag.Usage = usage
 	flag.Parse()
 
-	if help || (testName != "basic" && testName != "advance" && testName != "all") {
+	if help || (testName != "basic" && testName != "advance" && testName != "all" && testName != "sim" && testName != "fault") {
 		flag.Usage()
 		os.Exit(0)
 	}
 
+	var err error
+	nodeLogger, err = logging.New(logging.Config{File: logFile, Level: logLevel})
+	if err != nil {
+		_, _ = red.Println("Logging setup failed:", err)
+		os.Exit(1)
+	}
+
+	if useTLS {
+		dir, err := os.MkdirTemp("", "dht-tls")
+		if err == nil {
+			var cfg network.TLSConfig
+			cfg, err = network.GenerateTLS(dir, GetLocalAddress(), "127.0.0.1", "localhost")
+			tlsConfig = &cfg
+		}
+		if err != nil {
+			_, _ = red.Println("TLS setup failed:", err)
+			os.Exit(1)
+		}
+	}
+
 	// rand.Seed(0)
 	rand.Seed(time.Now().UnixNano())
+	if seed == 0 {
+		seed = rand.Int63()
+	}
 }
 
 func main() {
 	_, _ = yellow.Println("Welcome to DHT-2022 Test Program!\n")
 
+	if metricsAddr != "" {
+		if _, err := metrics.Default.ListenAndServe(metricsAddr); err != nil {
+			_, _ = red.Println("Metrics listener failed:", err)
+		} else {
+			_, _ = cyan.Printf("Serving metrics at http://%s/metrics\n", metricsAddr)
+		}
+	}
+
 	var basicFailRate float64
 	var forceQuitFailRate float64
 	var QASFailRate float64
 
 	switch testName {
+	case "sim":
+		simPanicked, simFailedCnt, simTotalCnt := simTest(seed)
+		if simPanicked {
+			_, _ = red.Printf("Simulated Test Panicked.")
+			os.Exit(0)
+		}
+		simFailRate := float64(simFailedCnt) / float64(simTotalCnt)
+		if simFailRate > simMaxFailRate {
+			_, _ = red.Printf("Simulated test failed with fail rate %.4f, replay with -seed %d\n", simFailRate, seed)
+		} else {
+			_, _ = green.Printf("Simulated test passed with fail rate %.4f\n", simFailRate)
+		}
+		return
+	case "fault":
+		faultPanicked, faultFailedCnt, faultTotalCnt := faultTest(seed)
+		if faultPanicked {
+			_, _ = red.Printf("Fault Test Panicked.")
+			os.Exit(0)
+		}
+		faultFailRate := float64(faultFailedCnt) / float64(faultTotalCnt)
+		if faultFailRate > faultMaxFailRate {
+			_, _ = red.Printf("Fault test failed with fail rate %.4f, seed %d\n", faultFailRate, seed)
+		} else {
+			_, _ = green.Printf("Fault test passed with fail rate %.4f\n", faultFailRate)
+		}
+		return
 	case "all":
 		fallthrough
 	case "basic":
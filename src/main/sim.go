@@ -0,0 +1,130 @@
+package main
+
+import (
+	"DHT-2022/src/chord"
+	"DHT-2022/src/logging"
+	"DHT-2022/src/sim"
+	"fmt"
+	"time"
+)
+
+const (
+	simNodeSize          int     = 30
+	simPutSize           int     = 200
+	simRoundNum          int     = 5
+	simRoundQuitNodeSize         = simNodeSize / (2 * simRoundNum)
+	simMaxFailRate       float64 = 0.15
+	simJoinTime                  = time.Second
+	simAfterJoinTime             = 10 * time.Second
+	simFQTime                    = 500 * time.Millisecond
+)
+
+// the force quit test run by the simulator, the same seed gives the same
+// run, whose digest is printed to tell runs apart
+func simTest(seed int64) (bool, int, int) {
+	_, _ = yellow.Printf("Start Simulated Force Quit Test (seed %d)\n", seed)
+
+	simFailedCnt, simTotalCnt, panicked := 0, 0, false
+
+	s := sim.New(seed)
+	rnd := s.Rand()
+	randKey := func() string {
+		b := make([]rune, lengthOfKeyValue)
+		for i := range b {
+			b[i] = letters[rnd.Intn(len(letters))]
+		}
+		return string(b)
+	}
+
+	nodes := new([simNodeSize + 1]*chord.ChordNode)
+	nodeAddresses := new([simNodeSize + 1]string)
+	kvKeys := make([]string, 0, simPutSize)
+	kvMap := make(map[string]string)
+	nodesInNetwork := make([]int, 0, simNodeSize+1)
+
+	for i := 0; i <= simNodeSize; i++ {
+		nodeAddresses[i] = fmt.Sprintf("node-%d", i)
+		node, err := s.Chord(nodeAddresses[i], chord.Config{Log: logging.Config{Logger: nodeLogger}})
+		if err != nil {
+			_, _ = red.Println("Simulated node failed:", err)
+			return true, 0, 0
+		}
+		nodes[i] = node
+		s.Add(node)
+	}
+
+	joinInfo := testInfo{
+		msg:       "Simulated join",
+		failedCnt: 0,
+		totalCnt:  0,
+	}
+	nodes[0].Create()
+	nodesInNetwork = append(nodesInNetwork, 0)
+	for i := 1; i <= simNodeSize; i++ {
+		ok := nodes[i].Join(nodeAddresses[rnd.Intn(i)])
+		s.Record("join %s %v", nodeAddresses[i], ok)
+		if !ok {
+			joinInfo.fail()
+		} else {
+			joinInfo.success()
+		}
+		nodesInNetwork = append(nodesInNetwork, i)
+		s.RunFor(simJoinTime)
+	}
+	joinInfo.finish(&simFailedCnt, &simTotalCnt)
+	s.RunFor(simAfterJoinTime)
+
+	putInfo := testInfo{
+		msg:       "Simulated put",
+		failedCnt: 0,
+		totalCnt:  0,
+	}
+	for i := 0; i < simPutSize; i++ {
+		key, value := randKey(), randKey()
+		kvKeys = append(kvKeys, key)
+		kvMap[key] = value
+		ok := nodes[rnd.Intn(simNodeSize+1)].Put(key, value)
+		s.Record("put %s %v", key, ok)
+		if !ok {
+			putInfo.fail()
+		} else {
+			putInfo.success()
+		}
+	}
+	putInfo.finish(&simFailedCnt, &simTotalCnt)
+
+	for t := 1; t <= simRoundNum; t++ {
+		for i := 1; i <= simRoundQuitNodeSize; i++ {
+			idxInArray := rnd.Intn(len(nodesInNetwork))
+			nodes[nodesInNetwork[idxInArray]].ForceQuit()
+			s.Record("force quit %s", nodeAddresses[nodesInNetwork[idxInArray]])
+			nodesInNetwork = removeFromArray(nodesInNetwork, idxInArray)
+			s.RunFor(simFQTime)
+		}
+
+		getInfo := testInfo{
+			msg:       fmt.Sprintf("Simulated get (round %d)", t),
+			failedCnt: 0,
+			totalCnt:  0,
+		}
+		// in the order of the puts, the order of a map is random
+		for _, key := range kvKeys {
+			ok, res := nodes[nodesInNetwork[rnd.Intn(len(nodesInNetwork))]].Get(key)
+			ok = ok && res == kvMap[key]
+			s.Record("get %s %v", key, ok)
+			if !ok {
+				getInfo.fail()
+			} else {
+				getInfo.success()
+			}
+		}
+		getInfo.finish(&simFailedCnt, &simTotalCnt)
+	}
+
+	for i := 0; i <= simNodeSize; i++ {
+		nodes[i].Quit()
+	}
+	_, _ = cyan.Printf("Simulated %v, trace digest %s\n", s.Now(), s.Digest())
+
+	return panicked, simFailedCnt, simTotalCnt
+}
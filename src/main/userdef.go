@@ -2,6 +2,7 @@ package main
 
 import (
 	"DHT-2022/src/chord"
+	"DHT-2022/src/identity"
 	"fmt"
 )
 
@@ -11,6 +12,19 @@ import (
 
 func NewNode(port int) dhtNode {
 	node := new(chord.ChordNode)
-	node.Initialize(GetLocalAddress() + ":" + fmt.Sprint(port))
+	cfg := chord.DefaultConfig()
+	cfg.Faults = faults
+	cfg.Log.Logger = nodeLogger
+	cfg.TLS = tlsConfig
+	cfg.ClusterKey = []byte(clusterKey)
+	cfg.M, cfg.Hash = idWidth, idHash
+	if useIdentity {
+		id, err := identity.Generate()
+		if err != nil {
+			panic(err)
+		}
+		cfg.Identity = id
+	}
+	node.Initialize(GetLocalAddress()+":"+fmt.Sprint(port), cfg)
 	return node
 }
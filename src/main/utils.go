@@ -108,9 +108,14 @@ func GetLocalAddress() string {
 }
 
 func randString(length int) string {
+	return randStringFrom(rand.Intn, length)
+}
+
+// random string drawn with INTN, e.g. the method of a seeded generator
+func randStringFrom(intn func(int) int, length int) string {
 	b := make([]rune, length)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		b[i] = letters[intn(len(letters))]
 	}
 	return string(b)
 }
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	lock    sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry the protocols record to
+var Default = NewRegistry()
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.metrics[m.name()]; ok {
+		panic("metric " + m.name() + " registered twice")
+	}
+	r.metrics[m.name()] = m
+}
+
+// WriteText writes every metric in the Prometheus text format, ordered by
+// name and by labels
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]metric, len(names))
+	for i, name := range names {
+		list[i] = r.metrics[name]
+	}
+	r.lock.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range list {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Serve exposes the registry at /metrics on L until L is closed
+func (r *Registry) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return server.Serve(l)
+}
+
+// ListenAndServe exposes the registry at /metrics on ADDR, it returns the
+// listener, closing it stops serving
+func (r *Registry) ListenAndServe(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go r.Serve(l)
+	return l, nil
+}
+
+// series of a metric, keyed by the label values joined by a zero byte
+type family struct {
+	fullName string
+	help     string
+	kind     string
+	labels   []string
+
+	lock   sync.Mutex
+	series map[string]interface{}
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{fullName: name, help: help, kind: kind, labels: labels, series: make(map[string]interface{})}
+}
+
+func (f *family) name() string {
+	return f.fullName
+}
+
+func (f *family) get(values []string, make func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", f.fullName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = make()
+		f.series[key] = s
+	}
+	return s
+}
+
+// Delete drops the series with the label VALUES
+func (f *family) Delete(values ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.series, strings.Join(values, "\x00"))
+}
+
+// DeleteMatch drops the series whose label LABEL has the value VALUE, e.g.
+// all the series of a node leaving
+func (f *family) DeleteMatch(label, value string) {
+	idx := -1
+	for i, l := range f.labels {
+		if l == label {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for key := range f.series {
+		if strings.Split(key, "\x00")[idx] == value {
+			delete(f.series, key)
+		}
+	}
+}
+
+// visit the series ordered by their labels
+func (f *family) each(foo func(labels string, s interface{})) {
+	f.lock.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]interface{}, len(keys))
+	for i, key := range keys {
+		series[i] = f.series[key]
+	}
+	f.lock.Unlock()
+	for i, key := range keys {
+		foo(f.labelText(key), series[i])
+	}
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.fullName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.fullName, f.kind)
+}
+
+// the labels of the series KEY as `a="x",b="y"`
+func (f *family) labelText(key string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\x00")
+	parts := make([]string, len(f.labels))
+	for i, l := range f.labels {
+		parts[i] = l + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func sample(w *bufio.Writer, name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Counter is a value that only goes up
+type Counter struct {
+	lock sync.Mutex
+	v    float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("counter decreased")
+	}
+	c.lock.Lock()
+	c.v += v
+	c.lock.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.v
+}
+
+type CounterVec struct {
+	family
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	ret := &CounterVec{newFamily(name, help, "counter", labels)}
+	r.register(ret)
+	return ret
+}
+
+// With gives the counter with the label VALUES, made on first use
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.get(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, s interface{}) {
+		sample(w, v.fullName, labels, s.(*Counter).Value())
+	})
+}
+
+// Gauge is a value read from a function when written out, e.g. the size
+// of a store
+type Gauge struct {
+	lock sync.Mutex
+	f    func() float64
+}
+
+// Set makes the gauge report V
+func (g *Gauge) Set(v float64) {
+	g.Func(func() float64 { return v })
+}
+
+// Func makes the gauge report what F returns
+func (g *Gauge) Func(f func() float64) {
+	g.lock.Lock()
+	g.f = f
+	g.lock.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.lock.Lock()
+	f := g.f
+	g.lock.Unlock()
+	if f == nil {
+		return 0
+	}
+	return f()
+}
+
+type GaugeVec struct {
+	family
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	ret := &GaugeVec{newFamily(name, help, "gauge", labels)}
+	r.register(ret)
+	return ret
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.get(values, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, s interface{}) {
+		sample(w, v.fullName, labels, s.(*Gauge).Value())
+	})
+}
+
+// Histogram counts observations in buckets of upper bounds
+type Histogram struct {
+	bounds []float64
+	lock   sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	// the first bucket whose bound is not less than V
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.lock.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.lock.Unlock()
+}
+
+// ObserveDuration observes D in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+type HistogramVec struct {
+	family
+	bounds []float64
+}
+
+// NewHistogramVec makes histograms with the upper bounds BUCKETS, the
+// +Inf bucket is implied
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	if n := len(bounds); n > 0 && math.IsInf(bounds[n-1], 1) {
+		bounds = bounds[:n-1]
+	}
+	ret := &HistogramVec{newFamily(name, help, "histogram", labels), bounds}
+	r.register(ret)
+	return ret
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.get(values, func() interface{} {
+		return &Histogram{bounds: v.bounds, counts: make([]uint64, len(v.bounds))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, s interface{}) {
+		h := s.(*Histogram)
+		h.lock.Lock()
+		counts, count, sum := append([]uint64(nil), h.counts...), h.count, h.sum
+		h.lock.Unlock()
+		var acc uint64
+		for i, bound := range h.bounds {
+			acc += counts[i]
+			sample(w, v.fullName+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), float64(acc))
+		}
+		sample(w, v.fullName+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+		sample(w, v.fullName+"_sum", labels, sum)
+		sample(w, v.fullName+"_count", labels, float64(count))
+	})
+}
+
+// LatencyBuckets are bounds in seconds fit for remote calls and lookups
+var LatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// HopBuckets are bounds fit for lookup hop counts
+var HopBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10, 12, 16, 24, 32}
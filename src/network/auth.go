@@ -0,0 +1,280 @@
+package network
+
+import (
+	"DHT-2022/src/clock"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthenticated = errors.New("request not authenticated")
+	errUnsigned        = fmt.Errorf("%w: unsigned", ErrUnauthenticated)
+)
+
+const (
+	// shortest cluster key accepted
+	MinClusterKey = 16
+	// requests signed further away in time are rejected
+	authMaxSkew = 30 * time.Second
+	nonceLen    = 16
+)
+
+// Auth signs the requests of a node with a key shared by the cluster, and
+// checks the ones it serves. A request carries the address it is sent to,
+// the time it is signed at and a random nonce, it is rejected if its
+// signature is wrong, if it is meant for another node, if it is signed
+// more than authMaxSkew away from now, or if its nonce has been seen within
+// that time. A nil Auth signs and checks nothing
+type Auth struct {
+	key   []byte
+	local string
+	clock clock.Clock
+
+	lock   sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewAuth makes the Auth of the node at LOCAL, nil if KEY is empty
+func NewAuth(key []byte, local string, clk clock.Clock) *Auth {
+	if len(key) == 0 {
+		return nil
+	}
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	return &Auth{
+		key:    append([]byte(nil), key...),
+		local:  local,
+		clock:  clk,
+		seen:   make(map[string]time.Time),
+		pruned: clk.Now(),
+	}
+}
+
+// signed request, the body is encoded apart so that it can be signed
+type authRequest struct {
+	ServiceMethod string
+	To            string
+	Seq           uint64
+	Time          int64
+	Nonce         []byte
+	Body          []byte
+	MAC           []byte
+}
+
+func (a *Auth) sign(r *authRequest) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	var num [8]byte
+	for _, field := range [][]byte{[]byte(r.ServiceMethod), []byte(r.To), r.Nonce, r.Body} {
+		binary.BigEndian.PutUint64(num[:], uint64(len(field)))
+		mac.Write(num[:])
+		mac.Write(field)
+	}
+	binary.BigEndian.PutUint64(num[:], r.Seq)
+	mac.Write(num[:])
+	binary.BigEndian.PutUint64(num[:], uint64(r.Time))
+	mac.Write(num[:])
+	return mac.Sum(nil)
+}
+
+func (a *Auth) verify(r *authRequest) error {
+	reason := ""
+	now := a.clock.Now()
+	skew := now.Sub(time.Unix(0, r.Time))
+	switch {
+	case len(r.MAC) == 0:
+		reason = "unsigned"
+	case !hmac.Equal(r.MAC, a.sign(r)):
+		reason = "bad_signature"
+	case r.To != a.local:
+		reason = "misdirected"
+	case skew > authMaxSkew || skew < -authMaxSkew:
+		reason = "stale"
+	case !a.fresh(string(r.Nonce), now):
+		reason = "replay"
+	default:
+		return nil
+	}
+	rpcRejected.With(a.local, reason).Inc()
+	if reason == "unsigned" {
+		return errUnsigned
+	}
+	return fmt.Errorf("%w: %s", ErrUnauthenticated, reason)
+}
+
+// record NONCE, false if it has been seen already. Nonces are forgotten
+// once the requests carrying them are too old to be accepted anyway
+func (a *Auth) fresh(nonce string, now time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now.Sub(a.pruned) > authMaxSkew {
+		for k, t := range a.seen {
+			if now.Sub(t) > 2*authMaxSkew {
+				delete(a.seen, k)
+			}
+		}
+		a.pruned = now
+	}
+	if _, ok := a.seen[nonce]; ok {
+		return false
+	}
+	a.seen[nonce] = now
+	return true
+}
+
+// NewClient makes an rpc client over CONN to the node at TO, signing its
+// requests for that node only
+func (a *Auth) NewClient(conn net.Conn, to string) *rpc.Client {
+	if a == nil {
+		return rpc.NewClient(conn)
+	}
+	buf := bufio.NewWriter(conn)
+	return rpc.NewClientWithCodec(&authClientCodec{
+		auth: a,
+		to:   to,
+		rwc:  conn,
+		dec:  gob.NewDecoder(conn),
+		enc:  gob.NewEncoder(buf),
+		buf:  buf,
+	})
+}
+
+// ServeConn serves CONN with SERVER, rejecting the requests not signed
+// with the key
+func (a *Auth) ServeConn(server *rpc.Server, conn net.Conn) {
+	if a == nil {
+		server.ServeConn(conn)
+		return
+	}
+	buf := bufio.NewWriter(conn)
+	server.ServeCodec(&authServerCodec{
+		auth: a,
+		rwc:  conn,
+		dec:  gob.NewDecoder(conn),
+		enc:  gob.NewEncoder(buf),
+		buf:  buf,
+	})
+}
+
+type authClientCodec struct {
+	auth *Auth
+	to   string
+	rwc  io.ReadWriteCloser
+	dec  *gob.Decoder
+	enc  *gob.Encoder
+	buf  *bufio.Writer
+}
+
+func (c *authClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(body); err != nil {
+		return err
+	}
+	req := authRequest{
+		ServiceMethod: r.ServiceMethod,
+		To:            c.to,
+		Seq:           r.Seq,
+		Time:          c.auth.clock.Now().UnixNano(),
+		Nonce:         make([]byte, nonceLen),
+		Body:          encoded.Bytes(),
+	}
+	if _, err := rand.Read(req.Nonce); err != nil {
+		return err
+	}
+	req.MAC = c.auth.sign(&req)
+	if err := c.enc.Encode(&req); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *authClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *authClientCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *authClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+type authServerCodec struct {
+	auth *Auth
+	rwc  io.ReadWriteCloser
+	dec  *gob.Decoder
+	enc  *gob.Encoder
+	buf  *bufio.Writer
+
+	// body of the request being read, and the reason it is rejected for
+	body     []byte
+	rejected error
+	closed   bool
+}
+
+func (c *authServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	var req authRequest
+	if err := c.dec.Decode(&req); err != nil {
+		// the body of an unsigned request follows it, and is not counted
+		// twice as the connection is dropped
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(c.rejected, errUnsigned) {
+			rpcRejected.With(c.auth.local, "malformed").Inc()
+		}
+		return err
+	}
+	r.ServiceMethod, r.Seq = req.ServiceMethod, req.Seq
+	c.body, c.rejected = req.Body, c.auth.verify(&req)
+	return nil
+}
+
+// a rejected request fails here, so that the server answers it with the
+// error and goes on with the connection
+func (c *authServerCodec) ReadRequestBody(body interface{}) error {
+	if c.rejected != nil {
+		return c.rejected
+	}
+	if body == nil {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(c.body)).Decode(body)
+}
+
+func (c *authServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		if c.buf.Flush() == nil {
+			// the header could not be encoded, which leaves the stream
+			// in an unknown state
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.buf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *authServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
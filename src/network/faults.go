@@ -0,0 +1,147 @@
+package network
+
+import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/dht"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LinkFaults describes how messages go on a link, each one is delayed by
+// Latency plus up to Jitter, and lost with probability Loss
+type LinkFaults struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+}
+
+// a lost message is taken as timed out after this long, unless the call
+// has an earlier deadline
+const defaultDropTimeout = time.Second
+
+// Faults injects latency, loss and partitions into the calls between
+// nodes, it is shared by the pools of the nodes under test. Requests and
+// replies are faulted on their own, so that a call may take effect while
+// its reply is lost
+type Faults struct {
+	// time taken to give up on a lost message
+	DropTimeout time.Duration
+	// the real clock by default
+	Clock clock.Clock
+
+	lock  sync.Mutex
+	rand  *rand.Rand
+	def   LinkFaults
+	links map[[2]string]LinkFaults
+	// partition of each node, nodes in different ones can not talk
+	group map[string]int
+}
+
+func NewFaults(seed int64) *Faults {
+	return &Faults{
+		DropTimeout: defaultDropTimeout,
+		Clock:       clock.Real{},
+		rand:        rand.New(rand.NewSource(seed)),
+		links:       make(map[[2]string]LinkFaults),
+		group:       make(map[string]int),
+	}
+}
+
+// SetDefault sets the faults of the links without faults of their own
+func (f *Faults) SetDefault(lf LinkFaults) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.def = lf
+}
+
+// SetLink sets the faults of the link from FROM to TO
+func (f *Faults) SetLink(from, to string, lf LinkFaults) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.links[[2]string{from, to}] = lf
+}
+
+// Partition splits the nodes into GROUPS, the nodes of different groups
+// can not talk to each other. Nodes in no group are in a group of their
+// own
+func (f *Faults) Partition(groups ...[]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.group = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			f.group[addr] = i + 1
+		}
+	}
+}
+
+// Heal ends the partition, the link faults are kept
+func (f *Faults) Heal() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.group = make(map[string]int)
+}
+
+// Reset drops every fault
+func (f *Faults) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.def = LinkFaults{}
+	f.links = make(map[[2]string]LinkFaults)
+	f.group = make(map[string]int)
+}
+
+// Partitioned reports whether A and B are in different partitions
+func (f *Faults) Partitioned(a, b string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.partitioned(a, b)
+}
+
+func (f *Faults) partitioned(a, b string) bool {
+	if len(f.group) == 0 {
+		return false
+	}
+	// a node in no group is alone
+	ga, ok := f.group[a]
+	if !ok {
+		return a != b
+	}
+	return ga != f.group[b]
+}
+
+// Send carries a message from FROM to TO, it waits for the delay of the
+// link, and fails with a timeout when the message is lost. A nil Faults
+// delivers at once
+func (f *Faults) Send(ctx context.Context, from, to string) error {
+	if f == nil || from == to {
+		return nil
+	}
+	f.lock.Lock()
+	lf, ok := f.links[[2]string{from, to}]
+	if !ok {
+		lf = f.def
+	}
+	lost := f.partitioned(from, to) || (lf.Loss > 0 && f.rand.Float64() < lf.Loss)
+	delay := lf.Latency
+	if lf.Jitter > 0 {
+		delay += time.Duration(f.rand.Int63n(int64(lf.Jitter)))
+	}
+	f.lock.Unlock()
+	if lost {
+		delay = f.DropTimeout
+	}
+	if delay > 0 {
+		select {
+		case <-f.Clock.After(delay):
+		case <-ctx.Done():
+			return dht.FromContext(ctx.Err())
+		}
+	}
+	if lost {
+		return dht.FromContext(context.DeadlineExceeded)
+	}
+	return nil
+}
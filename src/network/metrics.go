@@ -0,0 +1,37 @@
+package network
+
+import (
+	"DHT-2022/src/dht"
+	"DHT-2022/src/metrics"
+	"context"
+	"errors"
+)
+
+var (
+	rpcCalls = metrics.Default.NewCounterVec("dht_rpc_calls_total",
+		"Remote calls made by a node, by method and outcome.", "node", "method", "outcome")
+	dialFailures = metrics.Default.NewCounterVec("dht_dial_failures_total",
+		"Connections a node could not open to its peers.", "node")
+	rpcRejected = metrics.Default.NewCounterVec("dht_rpc_rejected_total",
+		"Requests a node rejected as not authenticated, by reason.", "node", "reason")
+)
+
+// OutcomeLabel names the outcome of a call ending with ERR, as recorded in
+// the metrics
+func OutcomeLabel(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(dht.FromRPC(err), dht.ErrNotFound):
+		return "not_found"
+	case isServerError(err):
+		return "remote_error"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, dht.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case err == ErrClosed || err == ErrExhausted:
+		return "pool"
+	}
+	return "unreachable"
+}
@@ -0,0 +1,379 @@
+package network
+
+import (
+	"DHT-2022/src/clock"
+	"DHT-2022/src/dht"
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed    = errors.New("connection pool closed")
+	ErrExhausted = errors.New("connection pool exhausted")
+)
+
+// Dialer opens a raw connection to ADDR, it gives up as soon as CTX is done
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+func DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+type PoolConfig struct {
+	DialAttempt int
+	DialTimeout time.Duration
+	// idle connections kept for each peer
+	MaxIdle int
+	// connections open at the same time in total, 0 for no limit
+	MaxOpen int
+	// idle connections unused for this long are closed
+	IdleTimeout time.Duration
+	// the real clock by default
+	Clock clock.Clock
+	// address of the node owning the pool, and the faults injected into
+	// its calls, if any
+	Local  string
+	Faults *Faults
+	// signs the requests of the clients, nil to send them unsigned
+	Auth *Auth
+}
+
+// Client is an rpc.Client checked out from a Pool, it must be given back
+// with Release once the call is over
+type Client struct {
+	*rpc.Client
+	addr   string
+	pool   *Pool
+	used   time.Time
+	reused bool
+}
+
+// Pool keeps the rpc clients to the peers of a node for reuse, a client is
+// used by one caller at a time. Clients failing at connection level are
+// evicted together with the idle ones to the same peer
+type Pool struct {
+	cfg  PoolConfig
+	dial Dialer
+
+	lock   sync.Mutex
+	idle   map[string][]*Client
+	open   int
+	freed  chan struct{}
+	closed bool
+	reaped time.Time
+}
+
+func NewPool(cfg PoolConfig, dial Dialer) *Pool {
+	if dial == nil {
+		dial = DialTCP
+	}
+	if cfg.DialAttempt < 1 {
+		cfg.DialAttempt = 1
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	return &Pool{
+		cfg:    cfg,
+		dial:   dial,
+		idle:   make(map[string][]*Client),
+		freed:  make(chan struct{}),
+		reaped: cfg.Clock.Now(),
+	}
+}
+
+// Get checks out a client to ADDR, reusing an idle one if any
+func (p *Pool) Get(ctx context.Context, addr string) (*Client, error) {
+	p.reap()
+	var wait <-chan time.Time
+	for {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrClosed
+		}
+		if list := p.idle[addr]; len(list) > 0 {
+			c := list[len(list)-1]
+			p.idle[addr] = list[:len(list)-1]
+			p.lock.Unlock()
+			c.reused = true
+			return c, nil
+		}
+		if p.cfg.MaxOpen <= 0 || p.open < p.cfg.MaxOpen {
+			p.open++
+			p.lock.Unlock()
+			break
+		}
+		// make room by closing the stalest idle client of other peers,
+		// otherwise wait for a client to be closed
+		if victim := p.stalest(); victim != nil {
+			p.open--
+			p.lock.Unlock()
+			victim.Client.Close()
+			continue
+		}
+		freed := p.freed
+		p.lock.Unlock()
+		if wait == nil {
+			// bounded so that nested calls at the cap never hang forever
+			wait = p.cfg.Clock.After(time.Duration(p.cfg.DialAttempt) * p.cfg.DialTimeout)
+		}
+		select {
+		case <-freed:
+		case <-wait:
+			return nil, ErrExhausted
+		case <-ctx.Done():
+			return nil, dht.FromContext(ctx.Err())
+		}
+	}
+	conn, err := p.dialConn(ctx, addr)
+	if err != nil {
+		if ctx.Err() == nil {
+			dialFailures.With(p.cfg.Local).Inc()
+		}
+		p.lock.Lock()
+		p.open--
+		p.notify()
+		p.lock.Unlock()
+		return nil, err
+	}
+	return &Client{Client: p.cfg.Auth.NewClient(conn, addr), addr: addr, pool: p}, nil
+}
+
+func (p *Pool) dialConn(ctx context.Context, addr string) (net.Conn, error) {
+	for i := 1; i <= p.cfg.DialAttempt; i++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.cfg.DialTimeout > 0 {
+			attemptCtx, cancel = p.cfg.Clock.WithTimeout(ctx, p.cfg.DialTimeout)
+		}
+		conn, err := p.dial(attemptCtx, addr)
+		// taken before cancel, which would make every attempt look timed out
+		timedOut := attemptCtx.Err() != nil
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, dht.FromContext(ctx.Err())
+		}
+		if !timedOut {
+			return nil, err
+		}
+	}
+	return nil, dht.ErrTimeout
+}
+
+// Release gives the client back after a call that ended with ERR, the
+// client is kept only if the connection is known to be fine
+func (c *Client) Release(err error) {
+	p := c.pool
+	if err != nil && !isServerError(err) {
+		c.Client.Close()
+		p.lock.Lock()
+		p.open--
+		p.notify()
+		p.lock.Unlock()
+		// the idle clients to a peer that broke a connection are likely
+		// broken as well
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			p.Evict(c.addr)
+		}
+		return
+	}
+	p.lock.Lock()
+	if p.closed || len(p.idle[c.addr]) >= p.cfg.MaxIdle {
+		p.open--
+		p.notify()
+		p.lock.Unlock()
+		c.Client.Close()
+		return
+	}
+	c.used = p.cfg.Clock.Now()
+	p.idle[c.addr] = append(p.idle[c.addr], c)
+	p.notify()
+	p.lock.Unlock()
+}
+
+// Call invokes METHOD at ADDR on a pooled client and gives up as soon as
+// CTX is done, the client is dropped then so that the call is aborted.
+// A call failing on a stale pooled client is retried on a fresh one
+func (p *Pool) Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	err := p.cfg.Faults.Send(ctx, p.cfg.Local, addr)
+	if err == nil {
+		err = p.call(ctx, addr, method, args, reply)
+	}
+	if err == nil {
+		err = p.cfg.Faults.Send(ctx, addr, p.cfg.Local)
+	}
+	rpcCalls.With(p.cfg.Local, method, OutcomeLabel(err)).Inc()
+	return err
+}
+
+func (p *Pool) call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	for {
+		c, err := p.Get(ctx, addr)
+		if err != nil {
+			return err
+		}
+		call := c.Go(method, args, reply, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+			err = call.Error
+		case <-ctx.Done():
+			c.Release(ctx.Err())
+			return dht.FromContext(ctx.Err())
+		}
+		c.Release(err)
+		// a client shut down before sending never reached the peer
+		if err == rpc.ErrShutdown && c.reused {
+			continue
+		}
+		return err
+	}
+}
+
+// Ping makes a round trip to the Health service at ADDR
+func (p *Pool) Ping(ctx context.Context, addr string) error {
+	var reply bool
+	return p.Call(ctx, addr, "Health.Ping", true, &reply)
+}
+
+// Evict closes the idle clients to ADDR
+func (p *Pool) Evict(addr string) {
+	p.lock.Lock()
+	list := p.idle[addr]
+	delete(p.idle, addr)
+	p.open -= len(list)
+	p.notify()
+	p.lock.Unlock()
+	for _, c := range list {
+		c.Client.Close()
+	}
+}
+
+// Flush closes all the idle clients
+func (p *Pool) Flush() {
+	p.flush(false)
+}
+
+// Close closes the idle clients, the ones checked out are closed when
+// released, and the pool can not be used any more
+func (p *Pool) Close() {
+	p.flush(true)
+}
+
+func (p *Pool) flush(final bool) {
+	p.lock.Lock()
+	p.closed = p.closed || final
+	idle := p.idle
+	p.idle = make(map[string][]*Client)
+	for _, list := range idle {
+		p.open -= len(list)
+	}
+	p.notify()
+	p.lock.Unlock()
+	for _, list := range idle {
+		for _, c := range list {
+			c.Client.Close()
+		}
+	}
+}
+
+// number of clients open, including the checked out ones
+func (p *Pool) Open() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.open
+}
+
+// close the idle clients unused for IdleTimeout, checked every half of it
+func (p *Pool) reap() {
+	if p.cfg.IdleTimeout <= 0 {
+		return
+	}
+	now := p.cfg.Clock.Now()
+	var expired []*Client
+	p.lock.Lock()
+	if now.Sub(p.reaped) < p.cfg.IdleTimeout/2 {
+		p.lock.Unlock()
+		return
+	}
+	p.reaped = now
+	for addr, list := range p.idle {
+		kept := list[:0]
+		for _, c := range list {
+			if now.Sub(c.used) >= p.cfg.IdleTimeout {
+				expired = append(expired, c)
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.idle, addr)
+		} else {
+			p.idle[addr] = kept
+		}
+	}
+	p.open -= len(expired)
+	p.notify()
+	p.lock.Unlock()
+	for _, c := range expired {
+		c.Client.Close()
+	}
+}
+
+// take out the idle client unused for the longest time, the caller holds
+// the lock
+func (p *Pool) stalest() *Client {
+	var (
+		ret  *Client
+		from string
+		idx  int
+	)
+	for addr, list := range p.idle {
+		for i, c := range list {
+			if ret == nil || c.used.Before(ret.used) {
+				ret, from, idx = c, addr, i
+			}
+		}
+	}
+	if ret != nil {
+		list := p.idle[from]
+		p.idle[from] = append(list[:idx], list[idx+1:]...)
+		if len(p.idle[from]) == 0 {
+			delete(p.idle, from)
+		}
+	}
+	return ret
+}
+
+// wake up the callers waiting for room, the caller holds the lock
+func (p *Pool) notify() {
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
+
+func isServerError(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return ok
+}
+
+// Outcome tells what the error of a call says about the peer, ALIVE if the
+// peer answered it and FAILED if the peer could not be reached. Calls given
+// up by the caller say nothing
+func Outcome(err error) (alive bool, failed bool) {
+	switch {
+	case err == nil || isServerError(err):
+		return true, false
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return false, false
+	case err == ErrClosed || err == ErrExhausted:
+		return false, false
+	}
+	return false, true
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+type health struct{}
+
+func (health) Ping(_ bool, reply *bool) error {
+	*reply = true
+	return nil
+}
+
+// RegisterHealth registers the Health service answering Pool.Ping
+func RegisterHealth(server *rpc.Server) error {
+	return server.RegisterName("Health", health{})
+}
+
+// ConnSet keeps the connections accepted by a server, with pooled clients
+// they outlive the listener and have to be closed when it goes offline
+type ConnSet struct {
+	// checks the requests served, nil to serve them all
+	Auth *Auth
+
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Serve serves CONN with SERVER until either side closes it
+func (s *ConnSet) Serve(server *rpc.Server, conn net.Conn) {
+	s.lock.Lock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.lock.Unlock()
+	s.Auth.ServeConn(server, conn)
+	s.lock.Lock()
+	delete(s.conns, conn)
+	s.lock.Unlock()
+}
+
+func (s *ConnSet) CloseAll() {
+	s.lock.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.lock.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSConfig locates the PEM files securing the traffic of a node
+type TLSConfig struct {
+	// certificate and key the node presents to its peers
+	CertFile string
+	KeyFile  string
+	// CA certificates the peers are verified against, the ones of the
+	// system if empty
+	CAFile string
+	// peers dialing in must present a certificate signed by the CAs too
+	MutualAuth bool
+	// name checked in the certificates of the peers, the host of the
+	// address dialed if empty
+	ServerName string
+}
+
+func (c TLSConfig) Validate() error {
+	switch {
+	case c.CertFile == "" || c.KeyFile == "":
+		return errors.New("invalid config: TLS needs CertFile and KeyFile")
+	case c.MutualAuth && c.CAFile == "":
+		return errors.New("invalid config: TLS MutualAuth needs CAFile")
+	}
+	return nil
+}
+
+// TLS secures the connections of another transport, the handshake of a
+// dial is made within its context
+type TLS struct {
+	inner  Transport
+	server *tls.Config
+	client *tls.Config
+}
+
+func NewTLS(cfg TLSConfig, inner Transport) (*TLS, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var roots *x509.CertPool
+	if cfg.CAFile != "" {
+		raw, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+	}
+	ret := &TLS{
+		inner: inner,
+		server: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+		client: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      roots,
+			ServerName:   cfg.ServerName,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	if cfg.MutualAuth {
+		ret.server.ClientAuth = tls.RequireAndVerifyClientCert
+		ret.server.ClientCAs = roots
+	}
+	return ret, nil
+}
+
+// Secure wraps INNER with TLS as set by CFG, INNER is kept if CFG is nil
+func Secure(inner Transport, cfg *TLSConfig) (Transport, error) {
+	if cfg == nil {
+		return inner, nil
+	}
+	return NewTLS(*cfg, inner)
+}
+
+func (t *TLS) Listen(addr string) (net.Listener, error) {
+	l, err := t.inner.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, t.server), nil
+}
+
+func (t *TLS) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := t.inner.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := t.client
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	ret := tls.Client(conn, cfg)
+	if err := ret.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ret, nil
+}
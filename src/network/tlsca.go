@@ -0,0 +1,97 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// validity of the certificates made by GenerateTLS
+const testCertValidity = 24 * time.Hour
+
+// GenerateTLS makes a self-signed CA and a certificate it signs for HOSTS,
+// IP addresses or DNS names, and writes them to DIR as ca.pem, node.pem and
+// node-key.pem. It is meant for local tests, where all the nodes share the
+// returned config with mutual authentication on
+func GenerateTLS(dir string, hosts ...string) (TLSConfig, error) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "DHT-2022 test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(testCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "DHT-2022 node"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(testCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// the same certificate serves both ends of a connection
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+
+	ret := TLSConfig{
+		CertFile:   filepath.Join(dir, "node.pem"),
+		KeyFile:    filepath.Join(dir, "node-key.pem"),
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		MutualAuth: true,
+	}
+	files := []struct {
+		path  string
+		block *pem.Block
+	}{
+		{ret.CAFile, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}},
+		{ret.CertFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}},
+		{ret.KeyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, pem.EncodeToMemory(f.block), 0600); err != nil {
+			return TLSConfig{}, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Transport carries the traffic of a node, the TCP one is used unless the
+// node is given another
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+type TCP struct{}
+
+func (TCP) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (TCP) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return DialTCP(ctx, addr)
+}
+
+var (
+	ErrRefused   = errors.New("connection refused")
+	ErrAddrInUse = errors.New("address already in use")
+)
+
+// Memory is an in-process network made of channels, where addresses are
+// arbitrary strings. Nodes sharing it reach each other without sockets
+type Memory struct {
+	lock      sync.Mutex
+	listeners map[string]*memListener
+}
+
+func NewMemory() *Memory {
+	return &Memory{listeners: make(map[string]*memListener)}
+}
+
+func (m *Memory) Listen(addr string) (net.Listener, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.listeners[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "mem", Addr: memAddr(addr), Err: ErrAddrInUse}
+	}
+	ret := &memListener{
+		net:   m,
+		addr:  memAddr(addr),
+		conns: make(chan net.Conn, memBacklog),
+		done:  make(chan struct{}),
+	}
+	m.listeners[addr] = ret
+	return ret, nil
+}
+
+func (m *Memory) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	m.lock.Lock()
+	l, ok := m.listeners[addr]
+	m.lock.Unlock()
+	refused := &net.OpError{Op: "dial", Net: "mem", Addr: memAddr(addr), Err: ErrRefused}
+	if !ok {
+		return nil, refused
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		return nil, refused
+	case <-ctx.Done():
+		client.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// connections waiting to be accepted by a listener
+const memBacklog = 128
+
+type memAddr string
+
+func (memAddr) Network() string  { return "mem" }
+func (a memAddr) String() string { return string(a) }
+
+type memListener struct {
+	net   *Memory
+	addr  memAddr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		l.net.lock.Lock()
+		if l.net.listeners[string(l.addr)] == l {
+			delete(l.net.listeners, string(l.addr))
+		}
+		l.net.lock.Unlock()
+		close(l.done)
+		// refuse the connections not accepted yet
+		for {
+			select {
+			case conn := <-l.conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
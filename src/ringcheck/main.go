@@ -0,0 +1,64 @@
+// Command ringcheck walks a Chord ring from any of its nodes and reports
+// every inconsistency of the successor and predecessor pointers and of the
+// placement of the keys, it exits with 1 if the ring has not converged
+package main
+
+import (
+	"DHT-2022/src/chord"
+	"DHT-2022/src/ident"
+	"DHT-2022/src/network"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	var (
+		addr    string
+		m       int
+		copies  int
+		timeout time.Duration
+		verbose bool
+		secure  network.TLSConfig
+		key     string
+		hash    ident.Hash
+	)
+	flag.StringVar(&addr, "addr", "", "address of any node on the ring")
+	flag.IntVar(&m, "m", chord.M, "width of the identifiers used by the nodes")
+	flag.IntVar(&copies, "replicas", 1, "successors keeping a copy of each key")
+	flag.Var(&hash, "hash", "hash of the identifiers used by the nodes: sha1, sha256 or sha3-256")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "time the whole check may take")
+	flag.BoolVar(&verbose, "v", false, "dump the state of every node on the ring")
+	flag.StringVar(&secure.CertFile, "tls-cert", "", "certificate to present to the nodes, TLS is used if set")
+	flag.StringVar(&secure.KeyFile, "tls-key", "", "key of the certificate")
+	flag.StringVar(&secure.CAFile, "tls-ca", "", "CA certificates the nodes are verified against")
+	flag.StringVar(&key, "cluster-key", "", "key the requests to the nodes are signed with")
+	flag.Parse()
+	if addr == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cfg := chord.Config{M: m, Hash: hash, ReplicaNum: copies, ClusterKey: []byte(key)}
+	if secure.CertFile != "" {
+		cfg.TLS = &secure
+	}
+	report, err := chord.CheckRing(ctx, addr, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ring check failed:", err)
+		os.Exit(2)
+	}
+	if verbose {
+		for _, info := range report.Ring {
+			fmt.Print(info)
+		}
+	}
+	fmt.Print(report)
+	if !report.Converged() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"DHT-2022/src/network"
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+type link [2]string
+
+// Network is an in-memory network whose delivery is scripted by the
+// simulation, a connection is refused when the link between its ends is
+// cut, or when it is lost at random with probability Loss. Connections
+// fail at once rather than time out, a simulation only moves the clock
+// between events and would wait forever otherwise
+type Network struct {
+	mem  *network.Memory
+	rand *rand.Rand
+
+	lock  sync.Mutex
+	loss  float64
+	cut   map[link]bool
+	conns map[link]map[*conn]bool
+}
+
+func newNetwork(rand *rand.Rand) *Network {
+	return &Network{
+		mem:   network.NewMemory(),
+		rand:  rand,
+		cut:   make(map[link]bool),
+		conns: make(map[link]map[*conn]bool),
+	}
+}
+
+// Transport is the view of the network from the node at ADDR
+func (n *Network) Transport(addr string) network.Transport {
+	return &transport{net: n, from: addr}
+}
+
+// SetLoss sets the chance of a new connection being lost
+func (n *Network) SetLoss(p float64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.loss = p
+}
+
+// Cut breaks the link between A and B in both directions, the connections
+// open on it are closed
+func (n *Network) Cut(a, b string) {
+	n.lock.Lock()
+	n.cut[link{a, b}], n.cut[link{b, a}] = true, true
+	var open []*conn
+	for _, l := range []link{{a, b}, {b, a}} {
+		for c := range n.conns[l] {
+			open = append(open, c)
+		}
+	}
+	n.lock.Unlock()
+	for _, c := range open {
+		c.Close()
+	}
+}
+
+func (n *Network) Heal(a, b string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.cut, link{a, b})
+	delete(n.cut, link{b, a})
+}
+
+// Partition cuts every link between GROUP and the other nodes of NODES
+func (n *Network) Partition(group, nodes []string) {
+	in := make(map[string]bool)
+	for _, a := range group {
+		in[a] = true
+	}
+	for _, a := range group {
+		for _, b := range nodes {
+			if !in[b] {
+				n.Cut(a, b)
+			}
+		}
+	}
+}
+
+// HealAll restores every link cut so far
+func (n *Network) HealAll() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.cut = make(map[link]bool)
+}
+
+// whether a connection from FROM reaches TO, the random draw is taken
+// under the lock so that concurrent dials do not race on the generator
+func (n *Network) deliver(from, to string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.cut[link{from, to}] {
+		return false
+	}
+	return n.loss <= 0 || n.rand.Float64() >= n.loss
+}
+
+func (n *Network) track(l link, c *conn) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.conns[l] == nil {
+		n.conns[l] = make(map[*conn]bool)
+	}
+	n.conns[l][c] = true
+}
+
+func (n *Network) untrack(l link, c *conn) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.conns[l], c)
+}
+
+type transport struct {
+	net  *Network
+	from string
+}
+
+func (t *transport) Listen(addr string) (net.Listener, error) {
+	return t.net.mem.Listen(addr)
+}
+
+func (t *transport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if !t.net.deliver(t.from, addr) {
+		return nil, &net.OpError{Op: "dial", Net: "mem", Err: network.ErrRefused}
+	}
+	raw, err := t.net.mem.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: raw, net: t.net, link: link{t.from, addr}}
+	t.net.track(c.link, c)
+	return c, nil
+}
+
+type conn struct {
+	net.Conn
+	net  *Network
+	link link
+}
+
+func (c *conn) Close() error {
+	c.net.untrack(c.link, c)
+	return c.Conn.Close()
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"DHT-2022/src/chord"
+	"DHT-2022/src/clock"
+	"DHT-2022/src/kademlia"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Epoch is the virtual time every simulation starts at
+var Epoch = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// maintenance rounds are run this often on each node, the tasks not due
+// yet by the intervals of the node are skipped
+const defaultTick = 50 * time.Millisecond
+
+// Node is a DHT node whose maintenance is driven by the simulation
+type Node interface {
+	Run()
+	Create()
+	Join(addr string) bool
+	Quit()
+	ForceQuit()
+	Maintain()
+	Put(key, value string) bool
+	Get(key string) (bool, string)
+	Delete(key string) bool
+}
+
+// Sim runs nodes under a virtual clock on a scripted network, everything
+// random is drawn from one seeded source, so that a run is replayed
+// exactly by the same seed. The nodes are configured as Manual, their
+// maintenance runs as events of the simulation one after another
+type Sim struct {
+	Clock *clock.Virtual
+	Net   *Network
+	// maintenance rounds are run this often on each node
+	Tick time.Duration
+
+	seed int64
+	rand *rand.Rand
+
+	lock  sync.Mutex
+	trace []string
+}
+
+func New(seed int64) *Sim {
+	// the network draws from its own source, it is not consumed by the
+	// scenario in between
+	return &Sim{
+		Clock: clock.NewVirtual(Epoch),
+		Net:   newNetwork(rand.New(rand.NewSource(seed ^ 0x5eed))),
+		Tick:  defaultTick,
+		seed:  seed,
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (s *Sim) Seed() int64 {
+	return s.seed
+}
+
+// Rand is the source of randomness of the scenario
+func (s *Sim) Rand() *rand.Rand {
+	return s.rand
+}
+
+// Now is the virtual time elapsed since the start
+func (s *Sim) Now() time.Duration {
+	return s.Clock.Since(Epoch)
+}
+
+// At runs F once the virtual time T since the start is reached
+func (s *Sim) At(t time.Duration, f func()) clock.Timer {
+	return s.After(t-s.Now(), f)
+}
+
+// After runs F once D has passed
+func (s *Sim) After(d time.Duration, f func()) clock.Timer {
+	return s.Clock.AfterFunc(d, f)
+}
+
+// Every runs F every D, starting after D
+func (s *Sim) Every(d time.Duration, f func()) clock.Timer {
+	return s.every(d, d, f)
+}
+
+func (s *Sim) every(first, d time.Duration, f func()) clock.Timer {
+	e := &periodic{}
+	var run func()
+	run = func() {
+		f()
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		if !e.stopped {
+			e.timer = s.Clock.AfterFunc(d, run)
+		}
+	}
+	e.timer = s.Clock.AfterFunc(first, run)
+	return e
+}
+
+type periodic struct {
+	lock    sync.Mutex
+	timer   clock.Timer
+	stopped bool
+}
+
+func (p *periodic) Stop() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stopped = true
+	return p.timer.Stop()
+}
+
+// RunFor runs the events due within D, in order
+func (s *Sim) RunFor(d time.Duration) {
+	s.Clock.Advance(d)
+}
+
+// Record appends an event to the trace of the run, stamped with the
+// virtual time
+func (s *Sim) Record(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.trace = append(s.trace, fmt.Sprintf("%v "+format, append([]interface{}{s.Now()}, args...)...))
+}
+
+func (s *Sim) Trace() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.trace...)
+}
+
+// Digest sums up the trace, two runs with the same digest went the same
+func (s *Sim) Digest() string {
+	h := sha256.New()
+	for _, e := range s.Trace() {
+		h.Write([]byte(e))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Add runs N and schedules its maintenance, the first round is at a
+// random offset within a tick so that the nodes do not run in lockstep
+func (s *Sim) Add(n Node) clock.Timer {
+	n.Run()
+	return s.every(time.Duration(s.rand.Int63n(int64(s.Tick)))+1, s.Tick, n.Maintain)
+}
+
+// Chord makes a chord node at ADDR driven by the simulation, CFG is taken
+// as is apart from its clock, transport and mode
+func (s *Sim) Chord(addr string, cfg chord.Config) (*chord.ChordNode, error) {
+	cfg.Clock, cfg.Transport, cfg.Manual = s.Clock, s.Net.Transport(addr), true
+	n := new(chord.ChordNode)
+	if err := n.Initialize(addr, cfg); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Kademlia makes a kademlia node at ADDR driven by the simulation, CFG is
+// taken as is apart from its clock, transport, mode and seed
+func (s *Sim) Kademlia(addr string, cfg kademlia.Config) (*kademlia.KademliaNode, error) {
+	cfg.Clock, cfg.Transport, cfg.Manual = s.Clock, s.Net.Transport(addr), true
+	cfg.Seed = s.rand.Int63() | 1
+	return kademlia.NewKademliaNode(addr, cfg)
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"DHT-2022/src/clock"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	// the log is compacted into a snapshot once it holds this many records
+	// and more records than there are keys
+	compactThreshold = 4096
+
+	opPut   = "put"
+	opDel   = "del"
+	opClear = "clear"
+)
+
+var ErrClosed = errors.New("store closed")
+
+// a single mutation appended to the write-ahead log, the keys and values
+// are kept as bytes, which JSON writes in base64, so that the ones not
+// valid UTF-8 come back as they were
+type walRecord struct {
+	Op  string `json:",omitempty"`
+	Key []byte `json:",omitempty"`
+	Val []byte `json:",omitempty"`
+}
+
+// FileOptions tunes how a FileEngine syncs its log to the disk
+type FileOptions struct {
+	// a write is synced at most this long after it returns, a crash of
+	// the machine loses the writes of the last interval at most. Every
+	// write is synced before it returns if zero
+	SyncInterval time.Duration
+	// the periodic syncs are timed by it, the real clock by default
+	Clock clock.Clock
+}
+
+// FileEngine serves reads from memory and keeps the content durable in a
+// directory, every mutation is appended to a write-ahead log which is
+// compacted into a snapshot from time to time. The log is synced as told
+// by FileOptions, and a snapshot is synced along with the directory
+// before the log is truncated
+type FileEngine struct {
+	lock    sync.Mutex
+	mem     *MemoryEngine
+	dir     string
+	wal     *os.File
+	enc     *json.Encoder
+	records int
+
+	opts FileOptions
+	// pending sync of the log, in periodic mode
+	syncTimer clock.Timer
+}
+
+// OpenFile opens the engine kept in DIR syncing every write, reloading the
+// last snapshot and replaying the log on it
+func OpenFile(dir string) (*FileEngine, error) {
+	return OpenFileWith(dir, FileOptions{})
+}
+
+// OpenFileWith opens the engine kept in DIR like OpenFile, with OPTS
+func OpenFileWith(dir string, opts FileOptions) (*FileEngine, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real{}
+	}
+	ret := &FileEngine{mem: NewMemory(), dir: dir, opts: opts}
+	raw, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err == nil {
+		var snap []walRecord
+		err = json.Unmarshal(raw, &snap)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range snap {
+			ret.mem.put(string(rec.Key), string(rec.Val))
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ret.records, err = replayLog(filepath.Join(dir, walFile), ret.mem)
+	if err != nil {
+		return nil, err
+	}
+	ret.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ret.enc = json.NewEncoder(ret.wal)
+	return ret, nil
+}
+
+func replayLog(path string, mem *MemoryEngine) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	cnt := 0
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var rec walRecord
+		// a torn record at the tail is left by a crash during the write,
+		// every complete record before it is still valid
+		if err := dec.Decode(&rec); err != nil {
+			return cnt, nil
+		}
+		cnt++
+		switch rec.Op {
+		case opPut:
+			mem.put(string(rec.Key), string(rec.Val))
+		case opDel:
+			mem.delete(string(rec.Key))
+		case opClear:
+			mem.Clear()
+		}
+	}
+}
+
+func (f *FileEngine) Get(key string) (string, bool) {
+	return f.mem.Get(key)
+}
+
+func (f *FileEngine) Put(key, val string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.wal == nil {
+		return ErrClosed
+	}
+	// the write is logged before it is seen, a write failing to be
+	// logged is not applied
+	if err := f.append(walRecord{Op: opPut, Key: []byte(key), Val: []byte(val)}); err != nil {
+		return err
+	}
+	f.mem.Put(key, val)
+	return f.maybeCompact()
+}
+
+func (f *FileEngine) Delete(key string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.wal == nil {
+		return ErrClosed
+	}
+	if err := f.append(walRecord{Op: opDel, Key: []byte(key)}); err != nil {
+		return err
+	}
+	f.mem.Delete(key)
+	return f.maybeCompact()
+}
+
+func (f *FileEngine) Clear() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.wal == nil {
+		return ErrClosed
+	}
+	if err := f.append(walRecord{Op: opClear}); err != nil {
+		return err
+	}
+	f.mem.Clear()
+	return f.maybeCompact()
+}
+
+func (f *FileEngine) ForEach(foo func(key, val string) bool) {
+	f.mem.ForEach(foo)
+}
+
+func (f *FileEngine) Select(match KeyFilter) map[string]string {
+	return f.mem.Select(match)
+}
+
+func (f *FileEngine) Extract(match KeyFilter) (map[string]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ret := make(map[string]string)
+	if f.wal == nil {
+		return ret, ErrClosed
+	}
+	for k, v := range f.mem.Select(match) {
+		if err := f.append(walRecord{Op: opDel, Key: []byte(k)}); err != nil {
+			return ret, err
+		}
+		f.mem.Delete(k)
+		ret[k] = v
+	}
+	return ret, f.maybeCompact()
+}
+
+func (f *FileEngine) Len() int {
+	return f.mem.Len()
+}
+
+func (f *FileEngine) Size() int64 {
+	return f.mem.Size()
+}
+
+// Compact writes the snapshot and truncates the log
+func (f *FileEngine) Compact() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.wal == nil {
+		return ErrClosed
+	}
+	return f.compact()
+}
+
+func (f *FileEngine) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.wal == nil {
+		return nil
+	}
+	if f.syncTimer != nil {
+		f.syncTimer.Stop()
+		f.syncTimer = nil
+	}
+	err := f.wal.Sync()
+	if cerr := f.wal.Close(); err == nil {
+		err = cerr
+	}
+	f.wal = nil
+	return err
+}
+
+func (f *FileEngine) Drop() error {
+	f.Close()
+	f.mem.Clear()
+	return os.RemoveAll(f.dir)
+}
+
+// log REC, synced as told by the options
+func (f *FileEngine) append(rec walRecord) error {
+	err := f.enc.Encode(rec)
+	if err != nil {
+		return err
+	}
+	f.records++
+	if f.opts.SyncInterval <= 0 {
+		return f.wal.Sync()
+	}
+	if f.syncTimer == nil {
+		f.syncTimer = f.opts.Clock.AfterFunc(f.opts.SyncInterval, f.syncLog)
+	}
+	return nil
+}
+
+// compact the log once it has grown past the threshold, after the records
+// are applied to memory so that the snapshot holds them
+func (f *FileEngine) maybeCompact() error {
+	if f.records >= compactThreshold && f.records > f.mem.Len() {
+		return f.compact()
+	}
+	return nil
+}
+
+// sync the log written since the last sync, in periodic mode
+func (f *FileEngine) syncLog() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.syncTimer = nil
+	if f.wal != nil {
+		f.wal.Sync()
+	}
+}
+
+func (f *FileEngine) compact() error {
+	snap := make([]walRecord, 0, f.mem.Len())
+	f.mem.ForEach(func(k, v string) bool {
+		snap = append(snap, walRecord{Key: []byte(k), Val: []byte(v)})
+		return true
+	})
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(f.dir, snapshotFile+".tmp")
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(raw); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp, filepath.Join(f.dir, snapshotFile))
+	if err == nil {
+		// the rename is durable only once the directory is synced, the log
+		// still holds everything until then
+		err = syncDir(f.dir)
+	}
+	if err != nil {
+		return err
+	}
+	f.records = 0
+	return f.wal.Truncate(0)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
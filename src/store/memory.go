@@ -0,0 +1,117 @@
+package store
+
+import (
+	"sync"
+)
+
+// MemoryEngine keeps everything in a map, nothing survives a restart
+type MemoryEngine struct {
+	lock  sync.RWMutex
+	store map[string]string
+	size  int64
+}
+
+func NewMemory() *MemoryEngine {
+	ret := new(MemoryEngine)
+	ret.store = make(map[string]string)
+	return ret
+}
+
+func (m *MemoryEngine) Get(key string) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	val, ok := m.store[key]
+	return val, ok
+}
+
+func (m *MemoryEngine) Put(key, val string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.put(key, val)
+	return nil
+}
+
+func (m *MemoryEngine) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.delete(key)
+	return nil
+}
+
+func (m *MemoryEngine) Clear() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.store = make(map[string]string)
+	m.size = 0
+	return nil
+}
+
+func (m *MemoryEngine) ForEach(foo func(key, val string) bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for k, v := range m.store {
+		if !foo(k, v) {
+			return
+		}
+	}
+}
+
+func (m *MemoryEngine) Select(match KeyFilter) map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make(map[string]string)
+	for k, v := range m.store {
+		if match(k) {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func (m *MemoryEngine) Extract(match KeyFilter) (map[string]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := make(map[string]string)
+	for k, v := range m.store {
+		if match(k) {
+			ret[k] = v
+			m.delete(k)
+		}
+	}
+	return ret, nil
+}
+
+func (m *MemoryEngine) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.store)
+}
+
+func (m *MemoryEngine) Size() int64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.size
+}
+
+func (m *MemoryEngine) Close() error {
+	return nil
+}
+
+func (m *MemoryEngine) Drop() error {
+	return m.Clear()
+}
+
+func (m *MemoryEngine) put(key, val string) {
+	if old, ok := m.store[key]; ok {
+		m.size -= int64(len(key) + len(old))
+	}
+	m.store[key] = val
+	m.size += int64(len(key) + len(val))
+}
+
+func (m *MemoryEngine) delete(key string) {
+	if old, ok := m.store[key]; ok {
+		m.size -= int64(len(key) + len(old))
+		delete(m.store, key)
+	}
+}
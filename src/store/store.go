@@ -0,0 +1,65 @@
+package store
+
+import (
+	"path/filepath"
+)
+
+// KeyFilter reports whether a key is selected
+type KeyFilter func(key string) bool
+
+// Engine is a key-value storage backend used by the nodes of both protocols,
+// implementations are safe for concurrent use
+type Engine interface {
+	Get(key string) (string, bool)
+	Put(key, val string) error
+	Delete(key string) error
+	// remove all the keys
+	Clear() error
+
+	// iterate over all the pairs, stop as soon as FOO returns false
+	ForEach(foo func(key, val string) bool)
+	// copy out the pairs whose key is selected by MATCH
+	Select(match KeyFilter) map[string]string
+	// remove the pairs whose key is selected by MATCH and return them, on
+	// error the ones removed so far are returned
+	Extract(match KeyFilter) (map[string]string, error)
+
+	// number of keys
+	Len() int
+	// total bytes of keys and values
+	Size() int64
+
+	Close() error
+	// close the engine and remove everything it keeps
+	Drop() error
+}
+
+// Opener creates the engine of a named store of a node, e.g. "data"
+// and "backup" of a chord node
+type Opener func(name string) (Engine, error)
+
+// Memory opens in-memory engines
+func Memory() Opener {
+	return func(string) (Engine, error) {
+		return NewMemory(), nil
+	}
+}
+
+// Disk opens file-backed engines syncing every write, each in a
+// sub-directory of DIR
+func Disk(dir string) Opener {
+	return DiskWith(dir, FileOptions{})
+}
+
+// DiskWith opens file-backed engines with OPTS, each in a sub-directory of
+// DIR
+func DiskWith(dir string, opts FileOptions) Opener {
+	return func(name string) (Engine, error) {
+		return OpenFileWith(filepath.Join(dir, name), opts)
+	}
+}
+
+// All selects every key
+func All(string) bool {
+	return true
+}